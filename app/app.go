@@ -1,15 +1,84 @@
 package app
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/auth"
 	"github.com/tomsteele/shellsquid/config"
+	"github.com/tomsteele/shellsquid/pcap"
+	"github.com/tomsteele/shellsquid/querylog"
+	"github.com/tomsteele/shellsquid/ratelimit"
+	"github.com/tomsteele/shellsquid/stats"
+	"github.com/tomsteele/shellsquid/throttle"
 	"github.com/unrolled/render"
 )
 
 // App is used by the server to pass around global data structures need by handlers.
 type App struct {
-	DB        *boltons.DB
-	JWTSecret []byte
-	Render    *render.Render
-	Config    *config.Config
+	DB            *boltons.DB
+	JWTSecret     []byte
+	Render        *render.Render
+	Config        *config.Config
+	Captures      *pcap.Store
+	Logins        *throttle.Limiter
+	UnknownLogins *throttle.Accounts
+	// Authenticator verifies credentials provided to UserToken.
+	Authenticator auth.Authenticator
+	// OIDC is the single sign-on provider, it is nil unless OIDC is enabled.
+	OIDC *auth.OIDC
+	// RateLimits, Connections and Stats enforce and count the traffic of each record.
+	RateLimits  *ratelimit.Limiter
+	Connections *ratelimit.Counter
+	Stats       *stats.Store
+	// Queries keeps the recent DNS queries handled by the proxy.
+	Queries *querylog.Log
+
+	halted int32
+	haltMu sync.Mutex
+	haltCh chan struct{}
+}
+
+// Halted returns true if the kill switch is engaged and proxies must not route any traffic.
+func (a *App) Halted() bool {
+	return atomic.LoadInt32(&a.halted) == 1
+}
+
+// HaltNotify returns a channel that is closed when the kill switch is engaged. Proxies use it to
+// stop traffic that is already being routed.
+func (a *App) HaltNotify() <-chan struct{} {
+	a.haltMu.Lock()
+	defer a.haltMu.Unlock()
+	if a.haltCh == nil {
+		a.haltCh = make(chan struct{})
+		if a.Halted() {
+			close(a.haltCh)
+		}
+	}
+	return a.haltCh
+}
+
+// SetHalted engages or releases the kill switch.
+func (a *App) SetHalted(halted bool) {
+	a.haltMu.Lock()
+	defer a.haltMu.Unlock()
+	var v int32
+	if halted {
+		v = 1
+	}
+	atomic.StoreInt32(&a.halted, v)
+	if a.haltCh == nil {
+		return
+	}
+	select {
+	case <-a.haltCh:
+		if !halted {
+			a.haltCh = nil
+		}
+	default:
+		if halted {
+			close(a.haltCh)
+		}
+	}
 }
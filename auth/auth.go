@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned when an authenticator does not accept the credentials.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator verifies a user's email and password and returns the matching user.
+// Implementations return ErrInvalidCredentials when the credentials are rejected, any
+// other error indicates the authenticator could not be reached or used.
+type Authenticator interface {
+	Authenticate(db *boltons.DB, email, password string) (*models.User, error)
+}
+
+// Chain tries each authenticator in order and returns the first user that is accepted.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(db *boltons.DB, email, password string) (*models.User, error) {
+	var lastErr error = ErrInvalidCredentials
+	for _, a := range c {
+		user, err := a.Authenticate(db, email, password)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrInvalidCredentials {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// Local authenticates users against the password hash stored in the database.
+type Local struct{}
+
+// Authenticate implements Authenticator.
+func (Local) Authenticate(db *boltons.DB, email, password string) (*models.User, error) {
+	user, err := models.FindUserByEmail(db, email)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == "" || user.Hash == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
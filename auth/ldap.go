@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/config"
+	"github.com/tomsteele/shellsquid/models"
+	"gopkg.in/ldap.v2"
+)
+
+// LDAP authenticates users by binding to a directory as them. Users are created in the database
+// the first time they log in. When a role mapping is configured their role is updated from their
+// group membership on each login.
+type LDAP struct {
+	Config *config.Config
+}
+
+// dial connects to the configured server, upgrading the connection with StartTLS if enabled.
+func (a *LDAP) dial() (*ldap.Conn, error) {
+	conf := a.Config.LDAP
+	host, _, err := net.SplitHostPort(conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+	var conn *ldap.Conn
+	if conf.TLS {
+		conn, err = ldap.DialTLS("tcp", conf.Server, tlsConfig)
+	} else {
+		conn, err = ldap.Dial("tcp", conf.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(10 * time.Second)
+	if conf.StartTLS && !conf.TLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// role maps the groups a user belongs to onto the most privileged configured role.
+func (a *LDAP) role(groups []string) string {
+	roles := []string{}
+	for _, group := range groups {
+		for dn, role := range a.Config.LDAP.RoleMapping {
+			if strings.EqualFold(strings.Replace(group, " ", "", -1), strings.Replace(dn, " ", "", -1)) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if role := models.HighestRole(roles); role != "" {
+		return role
+	}
+	return a.Config.LDAP.DefaultRole
+}
+
+// Authenticate implements Authenticator.
+func (a *LDAP) Authenticate(db *boltons.DB, email, password string) (*models.User, error) {
+	// An empty password would result in an unauthenticated bind which most servers accept.
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	existing, err := models.FindUserByEmail(db, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing.ID != "" && existing.Source != models.SourceLDAP {
+		return nil, ErrInvalidCredentials
+	}
+
+	conf := a.Config.LDAP
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if conf.BindDN != "" {
+		if err := conn.Bind(conf.BindDN, conf.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+	search := ldap.NewSearchRequest(
+		conf.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(conf.UserFilter, ldap.EscapeFilter(email)),
+		[]string{"dn", conf.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	role := a.role(entry.GetAttributeValues(conf.GroupAttribute))
+	if !models.ValidRole(role) {
+		log.Printf("ldap user %s is not in a group mapped to a role", email)
+		return nil, ErrInvalidCredentials
+	}
+	now := time.Now().Unix()
+	if existing.ID == "" {
+		user := &models.User{
+			Email:     email,
+			Role:      role,
+			Source:    models.SourceLDAP,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := db.Save(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if len(conf.RoleMapping) > 0 && existing.Role != role {
+		if err := db.Update(existing, map[string]interface{}{"Role": role, "UpdatedAt": now}); err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
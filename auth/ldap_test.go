@@ -0,0 +1,128 @@
+//go:build ldap
+// +build ldap
+
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/config"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// These tests need the directory from testdata/ldap/docker-compose.yml. Set
+// SHELLSQUID_LDAP_SERVER to use a server other than localhost:389.
+
+const (
+	operatorsDN = "cn=shellsquid-operators,ou=groups,dc=example,dc=org"
+	adminsDN    = "cn=shellsquid-admins,ou=groups,dc=example,dc=org"
+)
+
+func newLDAP(t *testing.T) (*LDAP, *boltons.DB) {
+	dir, err := ioutil.TempDir("", "shellsquid-ldap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := boltons.Open(filepath.Join(dir, "squid.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	conf := &config.Config{}
+	conf.LDAP.Enabled = true
+	conf.LDAP.Server = os.Getenv("SHELLSQUID_LDAP_SERVER")
+	if conf.LDAP.Server == "" {
+		conf.LDAP.Server = "localhost:389"
+	}
+	conf.LDAP.BindDN = "cn=admin,dc=example,dc=org"
+	conf.LDAP.BindPassword = "admin"
+	conf.LDAP.BaseDN = "dc=example,dc=org"
+	conf.LDAP.UserFilter = "(mail=%s)"
+	conf.LDAP.GroupAttribute = "memberOf"
+	conf.LDAP.RoleMapping = map[string]string{
+		operatorsDN: models.RoleOperator,
+		adminsDN:    models.RoleAdmin,
+	}
+	return &LDAP{Config: conf}, db
+}
+
+func TestLDAPRejectsInvalidCredentials(t *testing.T) {
+	a, db := newLDAP(t)
+	for _, c := range []struct{ email, password string }{
+		{"alice@example.org", "wrong"},
+		{"alice@example.org", ""},
+		{"nobody@example.org", "alice-password"},
+	} {
+		if _, err := a.Authenticate(db, c.email, c.password); err != ErrInvalidCredentials {
+			t.Errorf("Authenticate(%q, %q) = %v, want ErrInvalidCredentials", c.email, c.password, err)
+		}
+	}
+	if user, _ := models.FindUserByEmail(db, "alice@example.org"); user.ID != "" {
+		t.Error("a user was created for a failed login")
+	}
+}
+
+func TestLDAPCreatesUsersWithMappedRoles(t *testing.T) {
+	a, db := newLDAP(t)
+	for _, c := range []struct{ email, password, role string }{
+		{"alice@example.org", "alice-password", models.RoleOperator},
+		{"bob@example.org", "bob-password", models.RoleAdmin},
+	} {
+		user, err := a.Authenticate(db, c.email, c.password)
+		if err != nil {
+			t.Fatalf("Authenticate(%q) = %v", c.email, err)
+		}
+		if user.ID == "" || user.Role != c.role || user.Source != models.SourceLDAP || user.Hash != "" {
+			t.Errorf("Authenticate(%q) = %+v, want a saved %s user from ldap", c.email, user, c.role)
+		}
+		again, err := a.Authenticate(db, c.email, c.password)
+		if err != nil || again.ID != user.ID {
+			t.Errorf("second login of %q = %v, %v, want the same user", c.email, again, err)
+		}
+	}
+}
+
+func TestLDAPDefaultRole(t *testing.T) {
+	a, db := newLDAP(t)
+	if _, err := a.Authenticate(db, "carol@example.org", "carol-password"); err != ErrInvalidCredentials {
+		t.Errorf("user without a mapped group = %v, want ErrInvalidCredentials", err)
+	}
+	a.Config.LDAP.DefaultRole = models.RoleViewer
+	user, err := a.Authenticate(db, "carol@example.org", "carol-password")
+	if err != nil || user.Role != models.RoleViewer {
+		t.Errorf("user without a mapped group = %v, %v, want a viewer", user, err)
+	}
+}
+
+func TestLDAPRefreshesRoles(t *testing.T) {
+	a, db := newLDAP(t)
+	if _, err := a.Authenticate(db, "alice@example.org", "alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	a.Config.LDAP.RoleMapping[operatorsDN] = models.RoleViewer
+	user, err := a.Authenticate(db, "alice@example.org", "alice-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := models.FindUserByEmail(db, "alice@example.org")
+	if user.Role != models.RoleViewer || stored.Role != models.RoleViewer {
+		t.Errorf("role after the mapping changed = %s, stored %s, want viewer", user.Role, stored.Role)
+	}
+
+	// Without a mapping the role is managed in shellsquid and kept.
+	a.Config.LDAP.RoleMapping = nil
+	a.Config.LDAP.DefaultRole = models.RoleOperator
+	if err := db.Update(stored, map[string]interface{}{"Role": models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	user, err = a.Authenticate(db, "alice@example.org", "alice-password")
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Errorf("role without a mapping = %v, %v, want admin", user, err)
+	}
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/config"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// ErrOIDCIdentity is returned when a verified identity token does not satisfy the configured
+// requirements, such as an unverified email or a domain that is not allowed.
+var ErrOIDCIdentity = errors.New("identity is not allowed to log in")
+
+// discovery holds the parts of the provider's OpenID configuration that are used.
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDC performs the OpenID Connect authorization code flow against a single provider. The
+// provider configuration and signing keys are fetched on first use and cached.
+type OIDC struct {
+	Config *config.Config
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewOIDC returns an OIDC client for the provider in conf.
+func NewOIDC(conf *config.Config) *OIDC {
+	return &OIDC{
+		Config: conf,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (o *OIDC) getJSON(u string, v interface{}) error {
+	resp, err := o.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: unexpected status %d from %s", resp.StatusCode, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// provider returns the cached provider configuration, fetching it if needed.
+func (o *OIDC) provider() (*discovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	issuer := strings.TrimRight(o.Config.OIDC.Issuer, "/")
+	d := &discovery{}
+	if err := o.getJSON(issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: provider issuer %s does not match configured issuer", d.Issuer)
+	}
+	o.discovery = d
+	return d, nil
+}
+
+// key returns the provider's signing key with the given id, fetching the key set again if
+// the id is not known so that key rotation is picked up.
+func (o *OIDC) key(kid string) (*rsa.PublicKey, error) {
+	d, err := o.provider()
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := o.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	o.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	// Providers with a single key may omit the key id from tokens.
+	if kid == "" && len(o.keys) == 1 {
+		for _, k := range o.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// AuthCodeURL returns the provider URL to send the user to in order to log in.
+func (o *OIDC) AuthCodeURL(state, nonce, challenge string) (string, error) {
+	d, err := o.provider()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", o.Config.OIDC.ClientID)
+	v.Set("redirect_uri", o.Config.OIDC.RedirectURL)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of the identity token.
+func (o *OIDC) Exchange(code, verifier, nonce string) (map[string]interface{}, error) {
+	d, err := o.provider()
+	if err != nil {
+		return nil, err
+	}
+	conf := o.Config.OIDC
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	basic := len(d.TokenAuthMethods) == 0
+	for _, m := range d.TokenAuthMethods {
+		if m == "client_secret_basic" {
+			basic = true
+		}
+	}
+	if !basic {
+		form.Set("client_id", conf.ClientID)
+		form.Set("client_secret", conf.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d", resp.StatusCode)
+	}
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response did not include an id_token")
+	}
+	return o.verify(tokens.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, expiration and nonce of an identity token.
+func (o *OIDC) verify(idToken, nonce string) (map[string]interface{}, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return o.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, errors.New("oidc: identity token does not expire")
+	}
+	iss, _ := claims["iss"].(string)
+	if strings.TrimRight(iss, "/") != strings.TrimRight(o.Config.OIDC.Issuer, "/") {
+		return nil, errors.New("oidc: identity token has the wrong issuer")
+	}
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == o.Config.OIDC.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == o.Config.OIDC.ClientID {
+				audience = true
+			}
+		}
+	}
+	if !audience {
+		return nil, errors.New("oidc: identity token has the wrong audience")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("oidc: identity token has the wrong nonce")
+	}
+	return claims, nil
+}
+
+// role maps the groups in the identity token onto the most privileged configured role.
+func (o *OIDC) role(claims map[string]interface{}) string {
+	conf := o.Config.OIDC
+	roles := []string{}
+	if groups, ok := claims[conf.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				if role, ok := conf.RoleMapping[name]; ok {
+					roles = append(roles, role)
+				}
+			}
+		}
+	}
+	if role := models.HighestRole(roles); role != "" {
+		return role
+	}
+	return conf.DefaultRole
+}
+
+// User returns the user for a verified identity, creating them on their first login. The
+// identity must have a verified email in one of the allowed domains. When a role mapping is
+// configured the user's role is refreshed from their groups on each login.
+func (o *OIDC) User(db *boltons.DB, claims map[string]interface{}) (*models.User, error) {
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	if email == "" || !verified {
+		return nil, ErrOIDCIdentity
+	}
+	email = strings.ToLower(email)
+	if allowed := o.Config.OIDC.AllowedDomains; len(allowed) > 0 {
+		ok := false
+		for _, domain := range allowed {
+			if strings.HasSuffix(email, "@"+strings.ToLower(domain)) {
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, ErrOIDCIdentity
+		}
+	}
+	role := o.role(claims)
+	if !models.ValidRole(role) {
+		return nil, ErrOIDCIdentity
+	}
+	existing, err := models.FindUserByEmail(db, email)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if existing.ID == "" {
+		user := &models.User{
+			Email:     email,
+			Role:      role,
+			Source:    models.SourceOIDC,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := db.Save(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if existing.Source != models.SourceOIDC {
+		return nil, ErrOIDCIdentity
+	}
+	if len(o.Config.OIDC.RoleMapping) > 0 && existing.Role != role {
+		if err := db.Update(existing, map[string]interface{}{"Role": role, "UpdatedAt": now}); err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
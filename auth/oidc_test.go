@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/config"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+const (
+	testClientID     = "shellsquid"
+	testClientSecret = "secret"
+	testRedirectURL  = "https://localhost:1337/api/oidc/callback"
+)
+
+// authorization is a code issued by the mock provider after a login.
+type authorization struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+// mockProvider is an OpenID Connect provider that issues identity tokens signed with its
+// current key for codes registered with authorize.
+type mockProvider struct {
+	*httptest.Server
+	issuer string
+
+	mu    sync.Mutex
+	kid   string
+	key   *rsa.PrivateKey
+	codes map[string]authorization
+	jwks  int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	p := &mockProvider{codes: map[string]authorization{}}
+	p.rotate(t, "key-1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.issuer,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.jwks++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": p.kid,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	p.issuer = p.URL
+	t.Cleanup(p.Close)
+	return p
+}
+
+// rotate replaces the signing key of the provider.
+func (p *mockProvider) rotate(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kid = kid
+	p.key = key
+}
+
+// authorize plays the part of the user logging in at the provider's authorization endpoint,
+// returning the code the provider redirects back with.
+func (p *mockProvider) authorize(t *testing.T, authURL string, claims map[string]interface{}) (string, string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("response_type") != "code" || q.Get("client_id") != testClientID ||
+		q.Get("redirect_uri") != testRedirectURL || q.Get("code_challenge_method") != "S256" || q.Get("state") == "" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	claims["nonce"] = q.Get("nonce")
+	code := "code-" + q.Get("state")
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: q.Get("code_challenge"), claims: claims}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, req *http.Request) {
+	id, secret, ok := req.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := req.PostFormValue("code")
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	sum := sha256.Sum256([]byte(req.PostFormValue("code_verifier")))
+	if !ok || req.PostFormValue("grant_type") != "authorization_code" ||
+		req.PostFormValue("redirect_uri") != testRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = p.kid
+	token.Claims["iss"] = p.issuer
+	token.Claims["aud"] = testClientID
+	token.Claims["sub"] = "user-1"
+	token.Claims["iat"] = time.Now().Unix()
+	token.Claims["exp"] = time.Now().Add(time.Minute).Unix()
+	for k, v := range auth.claims {
+		token.Claims[k] = v
+	}
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+}
+
+func newOIDC(p *mockProvider) *OIDC {
+	conf := &config.Config{}
+	conf.OIDC.Enabled = true
+	conf.OIDC.Issuer = p.URL
+	conf.OIDC.ClientID = testClientID
+	conf.OIDC.ClientSecret = testClientSecret
+	conf.OIDC.RedirectURL = testRedirectURL
+	conf.OIDC.GroupsClaim = "groups"
+	conf.OIDC.RoleMapping = map[string]string{"shellsquid-operators": models.RoleOperator}
+	return NewOIDC(conf)
+}
+
+// login runs the authorization code flow for an identity with claims and returns the result
+// of the code exchange.
+func login(t *testing.T, p *mockProvider, o *OIDC, claims map[string]interface{}, verifier string) (map[string]interface{}, error) {
+	sum := sha256.Sum256([]byte("verifier-" + t.Name()))
+	authURL, err := o.AuthCodeURL("state-"+t.Name(), "nonce-"+t.Name(), base64.RawURLEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := p.authorize(t, authURL, claims)
+	if verifier == "" {
+		verifier = "verifier-" + t.Name()
+	}
+	return o.Exchange(code, verifier, "nonce-"+t.Name())
+}
+
+func identity() map[string]interface{} {
+	return map[string]interface{}{
+		"email":          "Alice@Example.org",
+		"email_verified": true,
+		"groups":         []string{"shellsquid-operators"},
+	}
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	p := newMockProvider(t)
+	o := newOIDC(p)
+	claims, err := login(t, p, o, identity(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "user-1" || claims["email"] != "Alice@Example.org" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	dir, err := ioutil.TempDir("", "shellsquid-oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := boltons.Open(filepath.Join(dir, "squid.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	user, err := o.User(db, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == "" || user.Email != "alice@example.org" || user.Role != models.RoleOperator || user.Source != models.SourceOIDC {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	p := newMockProvider(t)
+	if _, err := login(t, p, newOIDC(p), identity(), "some other verifier"); err == nil {
+		t.Error("code exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestOIDCVerifiesIdentityToken(t *testing.T) {
+	for name, change := range map[string]func(claims map[string]interface{}){
+		"issuer":   func(claims map[string]interface{}) { claims["iss"] = "https://attacker.example.org" },
+		"audience": func(claims map[string]interface{}) { claims["aud"] = "another-client" },
+		"nonce":    func(claims map[string]interface{}) { claims["nonce"] = "replayed" },
+		"expired":  func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+	} {
+		p := newMockProvider(t)
+		claims := identity()
+		o := newOIDC(p)
+		sum := sha256.Sum256([]byte("verifier"))
+		authURL, err := o.AuthCodeURL("state", "nonce", base64.RawURLEncoding.EncodeToString(sum[:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := p.authorize(t, authURL, claims)
+		change(claims)
+		if _, err := o.Exchange(code, "verifier", "nonce"); err == nil {
+			t.Errorf("identity token with the wrong %s was accepted", name)
+		}
+	}
+}
+
+func TestOIDCRejectsUnknownSigningKey(t *testing.T) {
+	p := newMockProvider(t)
+	o := newOIDC(p)
+	if _, err := login(t, p, o, identity(), ""); err != nil {
+		t.Fatal(err)
+	}
+	// A token signed with a key that is not published must be refused even if it claims a
+	// known key id.
+	forged, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = "key-1"
+	token.Claims["iss"] = p.issuer
+	token.Claims["aud"] = testClientID
+	token.Claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token.Claims["nonce"] = "nonce"
+	signed, err := token.SignedString(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.verify(signed, "nonce"); err == nil {
+		t.Error("identity token signed with an unpublished key was accepted")
+	}
+}
+
+func TestOIDCFetchesRotatedKeys(t *testing.T) {
+	p := newMockProvider(t)
+	o := newOIDC(p)
+	if _, err := login(t, p, o, identity(), ""); err != nil {
+		t.Fatal(err)
+	}
+	p.rotate(t, "key-2")
+	if _, err := login(t, p, o, identity(), ""); err != nil {
+		t.Fatalf("login after key rotation: %v", err)
+	}
+	if p.jwks != 2 {
+		t.Errorf("key set fetched %d times, want 2", p.jwks)
+	}
+}
+
+func TestOIDCRejectsMismatchedIssuer(t *testing.T) {
+	p := newMockProvider(t)
+	p.issuer = "https://accounts.example.org"
+	if _, err := newOIDC(p).AuthCodeURL("state", "nonce", "challenge"); err == nil {
+		t.Error("provider configuration with a different issuer was accepted")
+	}
+}
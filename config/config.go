@@ -9,8 +9,16 @@ import (
 type Config struct {
 	Proxy struct {
 		DNS struct {
-			Enabled  bool   `json:"enabled"`
-			Listener string `json:"listener"`
+			Enabled    bool   `json:"enabled"`
+			Listener   string `json:"listener"`
+			CaptureDir string `json:"capture_dir"`
+			// CaptureMaxSize is the size in megabytes at which a record's capture file is
+			// rotated, 0 disables rotation.
+			CaptureMaxSize int64 `json:"capture_max_size"`
+			// QueryLogSize is the number of recent queries kept in memory, QueryLogFile
+			// optionally appends every query to a file as JSON lines.
+			QueryLogSize int    `json:"query_log_size"`
+			QueryLogFile string `json:"query_log_file"`
 		} `json:"dns"`
 		SSL struct {
 			Enabled  bool   `json:"enabled"`
@@ -22,19 +30,80 @@ type Config struct {
 			Enabled  bool   `json:"enabled"`
 			Listener string `json:"listener"`
 		} `json:"http"`
+		// DoH serves DNS over HTTPS on /dns-query of the SSL proxy, or of its own listener if
+		// one is set. Key and Cert default to those of the SSL proxy.
+		DoH struct {
+			Enabled  bool   `json:"enabled"`
+			Listener string `json:"listener"`
+			Key      string `json:"key"`
+			Cert     string `json:"cert"`
+		} `json:"doh"`
+		// DoT serves DNS over TLS. Key and Cert default to those of the SSL proxy.
+		DoT struct {
+			Enabled  bool   `json:"enabled"`
+			Listener string `json:"listener"`
+			Key      string `json:"key"`
+			Cert     string `json:"cert"`
+		} `json:"dot"`
 	} `json:"proxy"`
 	Admin struct {
 		Listener string `json:"listener"`
 		Key      string `json:"key"`
 		Cert     string `json:"cert"`
 	} `json:"admin"`
-	JWTKey     string `json:"jwt_key"`
-	BoltDBFile string `json:"bolt_db_file"`
+	Login struct {
+		LockoutThreshold int   `json:"lockout_threshold"`
+		LockoutDuration  int64 `json:"lockout_duration"`
+		MaxBackoff       int64 `json:"max_backoff"`
+	} `json:"login"`
+	LDAP struct {
+		Enabled            bool              `json:"enabled"`
+		Server             string            `json:"server"`
+		TLS                bool              `json:"tls"`
+		StartTLS           bool              `json:"start_tls"`
+		InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+		BindDN             string            `json:"bind_dn"`
+		BindPassword       string            `json:"bind_password"`
+		BaseDN             string            `json:"base_dn"`
+		UserFilter         string            `json:"user_filter"`
+		GroupAttribute     string            `json:"group_attribute"`
+		RoleMapping        map[string]string `json:"role_mapping"`
+		DefaultRole        string            `json:"default_role"`
+	} `json:"ldap"`
+	OIDC struct {
+		Enabled           bool              `json:"enabled"`
+		Issuer            string            `json:"issuer"`
+		ClientID          string            `json:"client_id"`
+		ClientSecret      string            `json:"client_secret"`
+		RedirectURL       string            `json:"redirect_url"`
+		PostLoginRedirect string            `json:"post_login_redirect"`
+		AllowedDomains    []string          `json:"allowed_domains"`
+		GroupsClaim       string            `json:"groups_claim"`
+		RoleMapping       map[string]string `json:"role_mapping"`
+		DefaultRole       string            `json:"default_role"`
+	} `json:"oidc"`
+	JWTKey          string `json:"jwt_key"`
+	AccessTokenTTL  int64  `json:"access_token_ttl"`
+	RefreshTokenTTL int64  `json:"refresh_token_ttl"`
+	BoltDBFile      string `json:"bolt_db_file"`
 }
 
 // New parses JSON from the file provided by filename into a Config struct.
 func New(filename string) (*Config, error) {
-	config := &Config{}
+	config := &Config{
+		AccessTokenTTL:  900,
+		RefreshTokenTTL: 604800,
+	}
+	config.Login.LockoutThreshold = 10
+	config.Login.LockoutDuration = 900
+	config.Login.MaxBackoff = 60
+	config.Proxy.DNS.CaptureMaxSize = 100
+	config.Proxy.DNS.QueryLogSize = 10000
+	config.Proxy.DoT.Listener = ":853"
+	config.LDAP.UserFilter = "(mail=%s)"
+	config.LDAP.GroupAttribute = "memberOf"
+	config.OIDC.GroupsClaim = "groups"
+	config.OIDC.DefaultRole = "viewer"
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return config, err
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// rejectAPIKey writes a forbidden response and returns true if the request was authenticated
+// with an API key. Keys can not be used to manage other keys.
+func rejectAPIKey(server *app.App, w http.ResponseWriter, req *http.Request) bool {
+	if _, ok := context.Get(req, "apikey").(*models.APIKey); ok {
+		server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "API keys can not be managed using an API key"})
+		return true
+	}
+	return false
+}
+
+// CreateAPIKey handles a request to create a new API key for the current user. The key is only
+// returned in this response.
+func CreateAPIKey(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		keyReq := &models.APIKeyRequest{}
+		if err := binding.Bind(req, keyReq); err.Handle(w) {
+			return
+		}
+		key, plaintext, err := models.NewAPIKey(currentUser(req).ID, keyReq.Name, keyReq.Scope, keyReq.ExpiresAt)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error generating the API key"})
+			log.Println(err)
+			return
+		}
+		if err := server.DB.Save(key); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the API key to the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "apikey.create", "apikey", key.ID, nil, key)
+		server.Render.JSON(w, http.StatusCreated, map[string]interface{}{
+			"api_key": key,
+			"key":     plaintext,
+		})
+	}
+}
+
+// IndexAPIKey handles a request to list the current user's API keys. Admins see every key.
+func IndexAPIKey(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		user := currentUser(req)
+		id := user.ID
+		if user.IsAdmin() {
+			id = ""
+		}
+		keys, err := models.FindAPIKeysForUser(server.DB, id)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting API keys from the database"})
+			log.Println(err)
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, keys)
+	}
+}
+
+// DeleteAPIKey handles a request to revoke a single API key provided by the mux parameter id.
+func DeleteAPIKey(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		vars := mux.Vars(req)
+		key, err := models.FindAPIKeyByID(server.DB, vars["id"])
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting API key from the database"})
+			log.Println(err)
+			return
+		}
+		user := currentUser(req)
+		if key.ID == "" || (key.UserID != user.ID && !user.IsAdmin()) {
+			server.Render.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		if err := server.DB.Delete(key); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error deleting API key from the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, user, "apikey.delete", "apikey", key.ID, key, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nlf/boltons"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/config"
+	"github.com/unrolled/render"
+)
+
+// newTestApp returns an App backed by an empty database in a temporary directory that is
+// removed when the test ends.
+func newTestApp(t *testing.T) *app.App {
+	dir, err := ioutil.TempDir("", "shellsquid-handlers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := boltons.Open(filepath.Join(dir, "squid.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &app.App{
+		DB:        db,
+		JWTSecret: []byte("secret"),
+		Render:    render.New(),
+		Config:    &config.Config{AccessTokenTTL: 900, RefreshTokenTTL: 3600},
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// audit appends an entry to the audit log for an action taken by actor, which may be nil if
+// the client is not authenticated. before and after are the state of the target before and
+// after the action, only the fields that changed are kept. Failures are logged but do not stop
+// the request.
+func audit(server *app.App, req *http.Request, actor *models.User, action, targetType, targetID string, before, after interface{}) {
+	b, a, err := models.AuditDiff(before, after)
+	if err != nil {
+		log.Println(err)
+	}
+	// Password hashes are serialized with users, record only that they changed.
+	for _, m := range []map[string]interface{}{b, a} {
+		if _, ok := m["hash"]; ok {
+			delete(m, "hash")
+			m["password"] = "[redacted]"
+		}
+	}
+	entry := &models.AuditEntry{
+		IP:         remoteIP(req),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     b,
+		After:      a,
+	}
+	if actor != nil {
+		entry.ActorID = actor.ID
+		entry.Actor = actor.Email
+	}
+	if key, ok := context.Get(req, "apikey").(*models.APIKey); ok {
+		entry.APIKeyID = key.ID
+	}
+	if err := models.AppendAudit(server.DB, entry); err != nil {
+		log.Println(err)
+	}
+}
+
+// IndexAudit handles a request to return the audit log. Entries can be filtered with the query
+// parameters actor, action, target_type, target_id, ip, since and until. limit returns only the
+// most recent entries. With format=jsonl the entries are exported as JSON lines.
+func IndexAudit(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		query := req.URL.Query()
+		filter := models.AuditFilter{
+			Actor:      query.Get("actor"),
+			Action:     query.Get("action"),
+			TargetType: query.Get("target_type"),
+			TargetID:   query.Get("target_id"),
+			IP:         query.Get("ip"),
+		}
+		var err error
+		if s := query.Get("since"); s != "" {
+			if filter.Since, err = strconv.ParseInt(s, 10, 64); err != nil {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "since must be a unix timestamp"})
+				return
+			}
+		}
+		if s := query.Get("until"); s != "" {
+			if filter.Until, err = strconv.ParseInt(s, 10, 64); err != nil {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "until must be a unix timestamp"})
+				return
+			}
+		}
+		limit := 0
+		if s := query.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive number"})
+				return
+			}
+		}
+		entries, err := models.FindAuditEntries(server.DB, filter)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting the audit log from the database"})
+			log.Println(err)
+			return
+		}
+		if limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+		if query.Get("format") == "jsonl" || strings.Contains(req.Header.Get("Accept"), "application/x-ndjson") {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", "attachment; filename=audit.jsonl")
+			w.WriteHeader(http.StatusOK)
+			enc := json.NewEncoder(w)
+			for i := range entries {
+				if err := enc.Encode(&entries[i]); err != nil {
+					log.Println(err)
+					return
+				}
+			}
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, entries)
+	}
+}
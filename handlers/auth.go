@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// currentUser returns the user set into the request context by middleware.SetUserContext.
+func currentUser(req *http.Request) *models.User {
+	return context.Get(req, "user").(*models.User)
+}
+
+// requireRole checks the role set into the request context by middleware.SetUserContext. If the
+// role does not grant at least the permissions of required, a forbidden response is written and
+// false is returned.
+func requireRole(server *app.App, w http.ResponseWriter, req *http.Request, required string) bool {
+	role, _ := context.Get(req, "role").(string)
+	if !models.RoleAtLeast(role, required) {
+		server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "you do not have permission to perform this action"})
+		return false
+	}
+	return true
+}
+
+// remoteIP returns the IP address of the client that made the request.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// sanitizeUser removes fields from user that should not be returned to the current user.
+// The password hash is always removed, login failure details are only shown to admins.
+func sanitizeUser(req *http.Request, user *models.User) {
+	user.Hash = ""
+	if !currentUser(req).IsAdmin() {
+		user.FailedLogins = 0
+		user.LastFailedLogin = 0
+		user.LockedUntil = 0
+	}
+}
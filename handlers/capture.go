@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// ShowRecordCapture handles a request to download the DNS capture for a record provided by the mux parameter id.
+func ShowRecordCapture(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
+			return
+		}
+		id := record.ID
+		data, err := server.Captures.Read(id)
+		if os.IsNotExist(err) {
+			server.Render.JSON(w, http.StatusNotFound, map[string]string{"error": "no traffic has been captured for this record"})
+			return
+		}
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error reading the capture"})
+			log.Println(err)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+id+".pcap\"")
+		server.Render.Data(w, http.StatusOK, data)
+	}
+}
+
+// DeleteRecordCapture handles a request to remove the DNS capture for a record provided by the mux parameter id.
+func DeleteRecordCapture(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
+			return
+		}
+		id := record.ID
+		user := currentUser(req)
+		if !record.CanModify(user) {
+			forbidRecord(server, w)
+			return
+		}
+		if status, msg := checkProject(server, user, record.ProjectID); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
+			return
+		}
+		if err := server.Captures.Remove(id); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error deleting the capture"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, user, "capture.delete", "record", id, nil, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// testWriter is a dns.ResponseWriter that keeps the message written to it.
+type testWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+	closed bool
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *testWriter) RemoteAddr() net.Addr {
+	if w.remote == nil {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	}
+	return w.remote
+}
+
+func (w *testWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *testWriter) Write(b []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	return len(b), w.msg.Unpack(b)
+}
+
+func (w *testWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *testWriter) TsigStatus() error   { return nil }
+func (w *testWriter) TsigTimersOnly(bool) {}
+func (w *testWriter) Hijack()             {}
+
+// question returns a query for name of type qtype.
+func question(name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	return m
+}
+
+// rrStrings returns the presentation format of rrs.
+func rrStrings(rrs []dns.RR) []string {
+	s := []string{}
+	for _, rr := range rrs {
+		s = append(s, rr.String())
+	}
+	return s
+}
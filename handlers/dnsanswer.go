@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+var answerTypes = map[string]uint16{
+	models.AnswerA:     dns.TypeA,
+	models.AnswerAAAA:  dns.TypeAAAA,
+	models.AnswerCNAME: dns.TypeCNAME,
+	models.AnswerTXT:   dns.TypeTXT,
+	models.AnswerMX:    dns.TypeMX,
+}
+
+// txtStrings splits s into the 255 byte character strings of a TXT record.
+func txtStrings(s string) []string {
+	parts := []string{}
+	for len(s) > 255 {
+		parts = append(parts, s[:255])
+		s = s[255:]
+	}
+	return append(parts, s)
+}
+
+// answerRR returns answer as a resource record for name.
+func answerRR(name string, answer models.Answer) dns.RR {
+	hdr := dns.RR_Header{Name: name, Rrtype: answerTypes[answer.Type], Class: dns.ClassINET, Ttl: answer.AnswerTTL()}
+	switch answer.Type {
+	case models.AnswerA:
+		return &dns.A{Hdr: hdr, A: net.ParseIP(answer.Value)}
+	case models.AnswerAAAA:
+		return &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(answer.Value)}
+	case models.AnswerCNAME:
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(answer.Value)}
+	case models.AnswerMX:
+		return &dns.MX{Hdr: hdr, Preference: answer.Preference, Mx: dns.Fqdn(answer.Value)}
+	default:
+		return &dns.TXT{Hdr: hdr, Txt: txtStrings(answer.Value)}
+	}
+}
+
+// staticAnswer returns the authoritative response to req from answers. A CNAME answers every
+// query type. If no answer matches the query type the response is empty, with the SOA of zone
+// when the name belongs to one.
+func staticAnswer(req *dns.Msg, answers []models.Answer, zone *models.Zone) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	q := req.Question[0]
+	for _, answer := range answers {
+		qtype := answerTypes[answer.Type]
+		if q.Qtype == qtype || qtype == dns.TypeCNAME {
+			m.Answer = append(m.Answer, answerRR(q.Name, answer))
+		}
+	}
+	if len(m.Answer) == 0 && zone.ID != "" {
+		m.Ns = []dns.RR{zoneSOA(zone)}
+	}
+	return m
+}
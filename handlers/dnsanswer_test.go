@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+func TestStaticAnswer(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	answers := []models.Answer{
+		{Type: models.AnswerA, Value: "192.0.2.10"},
+		{Type: models.AnswerA, Value: "192.0.2.11", TTL: 60},
+		{Type: models.AnswerAAAA, Value: "2001:db8::10"},
+		{Type: models.AnswerTXT, Value: "v=spf1 -all"},
+		{Type: models.AnswerTXT, Value: long},
+		{Type: models.AnswerMX, Value: "mail.example.com", Preference: 10},
+	}
+	tests := []struct {
+		name    string
+		qtype   uint16
+		answers []models.Answer
+		zone    *models.Zone
+		answer  []string
+		ns      []string
+	}{
+		{"www.example.com.", dns.TypeA, answers, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tA\t192.0.2.10",
+			"www.example.com.\t60\tIN\tA\t192.0.2.11",
+		}, []string{}},
+		{"www.example.com.", dns.TypeAAAA, answers, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tAAAA\t2001:db8::10",
+		}, []string{}},
+		{"www.example.com.", dns.TypeTXT, answers, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tTXT\t\"v=spf1 -all\"",
+			"www.example.com.\t300\tIN\tTXT\t\"" + long[:255] + "\" \"" + long[255:] + "\"",
+		}, []string{}},
+		{"www.example.com.", dns.TypeMX, answers, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tMX\t10 mail.example.com.",
+		}, []string{}},
+		// The query name is answered as asked, so a wildcard answers each subdomain.
+		{"X.www.example.com.", dns.TypeA, answers[:1], &models.Zone{}, []string{
+			"X.www.example.com.\t300\tIN\tA\t192.0.2.10",
+		}, []string{}},
+		{"www.example.com.", dns.TypeA, []models.Answer{{Type: models.AnswerCNAME, Value: "target.example.net"}}, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tCNAME\ttarget.example.net.",
+		}, []string{}},
+		{"www.example.com.", dns.TypeTXT, []models.Answer{{Type: models.AnswerCNAME, Value: "target.example.net."}}, &models.Zone{}, []string{
+			"www.example.com.\t300\tIN\tCNAME\ttarget.example.net.",
+		}, []string{}},
+		{"www.example.com.", dns.TypeSRV, answers, &models.Zone{}, []string{}, []string{}},
+		{"www.example.com.", dns.TypeSRV, answers, testZone, []string{}, []string{testSOA}},
+	}
+	for _, tt := range tests {
+		m := staticAnswer(question(tt.name, tt.qtype), tt.answers, tt.zone)
+		if !m.Authoritative || m.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s %s: authoritative %v rcode %d, want true 0", tt.name, dns.TypeToString[tt.qtype], m.Authoritative, m.Rcode)
+		}
+		if got := rrStrings(m.Answer); !reflect.DeepEqual(got, tt.answer) {
+			t.Errorf("%s %s: answer = %q, want %q", tt.name, dns.TypeToString[tt.qtype], got, tt.answer)
+		}
+		if got := rrStrings(m.Ns); !reflect.DeepEqual(got, tt.ns) {
+			t.Errorf("%s %s: authority = %q, want %q", tt.name, dns.TypeToString[tt.qtype], got, tt.ns)
+		}
+	}
+}
+
+func TestTXTStrings(t *testing.T) {
+	tests := []struct {
+		length int
+		parts  []int
+	}{
+		{0, []int{0}},
+		{255, []int{255}},
+		{256, []int{255, 1}},
+		{600, []int{255, 255, 90}},
+	}
+	for _, tt := range tests {
+		var parts []int
+		for _, s := range txtStrings(strings.Repeat("a", tt.length)) {
+			parts = append(parts, len(s))
+		}
+		if !reflect.DeepEqual(parts, tt.parts) {
+			t.Errorf("txtStrings of %d bytes = %v, want %v", tt.length, parts, tt.parts)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// failureSOA returns the SOA for a negative answer to name. It is the SOA of zone if the name
+// belongs to one, otherwise one is synthesized for owner.
+func failureSOA(zone *models.Zone, owner string) dns.RR {
+	if zone.ID != "" {
+		return zoneSOA(zone)
+	}
+	owner = models.CanonicalName(owner)
+	return zoneSOA(&models.Zone{
+		Name:        owner,
+		NameServers: []models.NameServer{{Name: owner}},
+		Mbox:        "hostmaster." + owner,
+		Serial:      uint32(time.Now().Truncate(24 * time.Hour).Unix()),
+		TTL:         models.DefaultZoneMinimum,
+		Refresh:     models.DefaultZoneRefresh,
+		Retry:       models.DefaultZoneRetry,
+		Expire:      models.DefaultZoneExpire,
+		Minimum:     models.DefaultZoneMinimum,
+	})
+}
+
+// failDNS responds to a DNS query that can not be routed. The failure of record is used if it
+// has one, otherwise the global failure from the settings. record is nil if no record matched.
+func failDNS(server *app.App, w dns.ResponseWriter, req *dns.Msg, record *models.Record, zone *models.Zone) {
+	settings, err := models.GetSettings(server.DB)
+	if err != nil {
+		log.Println(err)
+	}
+	failure := settings.DNSFailure
+	owner := req.Question[0].Name
+	if record != nil {
+		failure = record.Failure.Or(failure)
+		owner = record.FQDN
+	}
+	failure = failure.Or(models.Failure{})
+
+	m := new(dns.Msg)
+	switch failure.Action {
+	case models.FailureDrop:
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			w.Close()
+		}
+		return
+	case models.FailureRefused:
+		refuseDNS(w, req)
+		return
+	case models.FailureNXDomain, models.FailureNoData:
+		m.SetReply(req)
+		m.Authoritative = true
+		if failure.Action == models.FailureNXDomain {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = []dns.RR{failureSOA(zone, owner)}
+	case models.FailureDecoy:
+		m = staticAnswer(req, failure.Answers, zone)
+	default:
+		dns.HandleFailed(w, req)
+		return
+	}
+	w.WriteMsg(m)
+}
+
+// noRecordDNS responds to a DNS query as if no record matched. Names in a zone do not exist,
+// others get the global failure.
+func noRecordDNS(server *app.App, w dns.ResponseWriter, req *dns.Msg, zone *models.Zone) {
+	if zone.ID != "" {
+		answerZone(w, req, zone)
+		return
+	}
+	failDNS(server, w, req, nil, zone)
+}
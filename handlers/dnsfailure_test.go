@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+func TestFailDNS(t *testing.T) {
+	udp := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	tcp := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	decoy := models.Failure{Action: models.FailureDecoy, Answers: []models.Answer{{Type: models.AnswerA, Value: "192.0.2.99"}}}
+	tests := []struct {
+		name    string
+		global  models.Failure
+		failure *models.Failure
+		zone    *models.Zone
+		remote  net.Addr
+		// rcode is -1 if no response is written.
+		rcode  int
+		closed bool
+		answer int
+		soa    string
+	}{
+		{"default", models.Failure{}, nil, &models.Zone{}, udp, dns.RcodeServerFailure, false, 0, ""},
+		{"record default", models.Failure{}, &models.Failure{}, &models.Zone{}, udp, dns.RcodeServerFailure, false, 0, ""},
+		{"global", models.Failure{Action: models.FailureRefused}, nil, &models.Zone{}, udp, dns.RcodeRefused, false, 0, ""},
+		{"record inherits global", models.Failure{Action: models.FailureRefused}, &models.Failure{}, &models.Zone{}, udp, dns.RcodeRefused, false, 0, ""},
+		{"record overrides global", models.Failure{Action: models.FailureRefused}, &models.Failure{Action: models.FailureServFail}, &models.Zone{}, udp, dns.RcodeServerFailure, false, 0, ""},
+		{"nxdomain", models.Failure{}, &models.Failure{Action: models.FailureNXDomain}, &models.Zone{}, udp, dns.RcodeNameError, false, 0, "c2.example.org."},
+		{"nxdomain in zone", models.Failure{}, &models.Failure{Action: models.FailureNXDomain}, testZone, udp, dns.RcodeNameError, false, 0, "example.com."},
+		{"global nxdomain", models.Failure{Action: models.FailureNXDomain}, nil, &models.Zone{}, udp, dns.RcodeNameError, false, 0, "x.c2.example.org."},
+		{"nodata", models.Failure{}, &models.Failure{Action: models.FailureNoData}, &models.Zone{}, udp, dns.RcodeSuccess, false, 0, "c2.example.org."},
+		{"decoy", models.Failure{}, &decoy, &models.Zone{}, udp, dns.RcodeSuccess, false, 1, ""},
+		{"global decoy", decoy, nil, &models.Zone{}, udp, dns.RcodeSuccess, false, 1, ""},
+		{"drop udp", models.Failure{}, &models.Failure{Action: models.FailureDrop}, &models.Zone{}, udp, -1, false, 0, ""},
+		{"drop tcp", models.Failure{}, &models.Failure{Action: models.FailureDrop}, &models.Zone{}, tcp, -1, true, 0, ""},
+	}
+	for _, tt := range tests {
+		server := newTestApp(t)
+		settings, err := models.GetSettings(server.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings.DNSFailure = tt.global
+		if err := server.DB.Save(settings); err != nil {
+			t.Fatal(err)
+		}
+		var record *models.Record
+		if tt.failure != nil {
+			record = &models.Record{ID: "c2", FQDN: "c2.example.org", Failure: *tt.failure}
+		}
+		w := &testWriter{remote: tt.remote}
+		failDNS(server, w, question("x.c2.example.org", dns.TypeA), record, tt.zone)
+
+		if w.closed != tt.closed {
+			t.Errorf("%s: closed = %v, want %v", tt.name, w.closed, tt.closed)
+		}
+		if tt.rcode == -1 {
+			if w.msg != nil {
+				t.Errorf("%s: response written to a dropped query", tt.name)
+			}
+			continue
+		}
+		if w.msg == nil {
+			t.Errorf("%s: no response", tt.name)
+			continue
+		}
+		if w.msg.Rcode != tt.rcode || len(w.msg.Answer) != tt.answer {
+			t.Errorf("%s: rcode %d with %d answers, want %d with %d", tt.name, w.msg.Rcode, len(w.msg.Answer), tt.rcode, tt.answer)
+		}
+		if tt.answer > 0 && w.msg.Answer[0].String() != "x.c2.example.org.\t300\tIN\tA\t192.0.2.99" {
+			t.Errorf("%s: answer = %s", tt.name, w.msg.Answer[0])
+		}
+		if tt.soa == "" {
+			if len(w.msg.Ns) != 0 {
+				t.Errorf("%s: unexpected authority %v", tt.name, w.msg.Ns)
+			}
+			continue
+		}
+		if len(w.msg.Ns) != 1 {
+			t.Errorf("%s: authority = %v, want one SOA", tt.name, w.msg.Ns)
+			continue
+		}
+		soa, ok := w.msg.Ns[0].(*dns.SOA)
+		if !ok || soa.Hdr.Name != tt.soa || !w.msg.Authoritative {
+			t.Errorf("%s: authority = %v, want the SOA of %s", tt.name, w.msg.Ns[0], tt.soa)
+		}
+	}
+}
+
+func TestNoRecordDNS(t *testing.T) {
+	server := newTestApp(t)
+	w := &testWriter{}
+	noRecordDNS(server, w, question("www.example.com", dns.TypeA), testZone)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeNameError || !w.msg.Authoritative {
+		t.Errorf("name in a zone = %v, want an authoritative NXDOMAIN", w.msg)
+	}
+	w = &testWriter{}
+	noRecordDNS(server, w, question("www.example.org", dns.TypeA), &models.Zone{})
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("name outside zones = %v, want SERVFAIL", w.msg)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+	"github.com/tomsteele/shellsquid/querylog"
+)
+
+// queryWriter records the response code written for a DNS query.
+type queryWriter struct {
+	dns.ResponseWriter
+	rcode string
+}
+
+func (q *queryWriter) WriteMsg(m *dns.Msg) error {
+	q.rcode = dns.RcodeToString[m.Rcode]
+	return q.ResponseWriter.WriteMsg(m)
+}
+
+// logQuery adds the query req, routed to record, to the query log. record may be empty if no
+// record matched.
+func logQuery(server *app.App, w *queryWriter, req *dns.Msg, record *models.Record, start time.Time) {
+	q := req.Question[0]
+	ip, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+	entry := querylog.Entry{
+		Resolver:   ip,
+		QName:      q.Name,
+		QType:      dns.TypeToString[q.Qtype],
+		RecordID:   record.ID,
+		RecordFQDN: record.FQDN,
+		Rcode:      w.rcode,
+		LatencyMS:  float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+	}
+	if entry.QType == "" {
+		entry.QType = strconv.Itoa(int(q.Qtype))
+	}
+	// Add only returns an error the first time writing to the query log file fails.
+	if err := server.Queries.Add(entry, start); err != nil {
+		log.Println(err)
+	}
+}
+
+// findQueries returns the logged DNS queries selected by the query parameters record_id,
+// resolver, qname, qtype, rcode, since and until that are visible to the current user, keeping
+// only the most recent limit queries if limit is not zero. Only admins see queries that did not
+// match a record. It writes an error response and returns false if a parameter is invalid.
+func findQueries(server *app.App, w http.ResponseWriter, req *http.Request, limit int) ([]querylog.Entry, bool) {
+	query := req.URL.Query()
+	filter := querylog.Filter{
+		RecordID: query.Get("record_id"),
+		Resolver: query.Get("resolver"),
+		QName:    query.Get("qname"),
+		QType:    query.Get("qtype"),
+		Rcode:    query.Get("rcode"),
+	}
+	var err error
+	if s := query.Get("since"); s != "" {
+		if filter.Since, err = strconv.ParseInt(s, 10, 64); err != nil {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "since must be a unix timestamp"})
+			return nil, false
+		}
+	}
+	if s := query.Get("until"); s != "" {
+		if filter.Until, err = strconv.ParseInt(s, 10, 64); err != nil {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "until must be a unix timestamp"})
+			return nil, false
+		}
+	}
+	if user := currentUser(req); !user.IsAdmin() {
+		records, err := models.FindRecordsForUser(server.DB, user)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting records from the database"})
+			log.Println(err)
+			return nil, false
+		}
+		filter.Records = map[string]bool{}
+		for _, r := range records {
+			filter.Records[r.ID] = true
+		}
+	}
+	return server.Queries.Find(filter, limit), true
+}
+
+// IndexDNSQueries handles a request to return the recent DNS queries, oldest first. See
+// findQueries for the filters, limit returns only the most recent queries.
+func IndexDNSQueries(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		limit := 0
+		if s := req.URL.Query().Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive number"})
+				return
+			}
+		}
+		entries, ok := findQueries(server, w, req, limit)
+		if !ok {
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, entries)
+	}
+}
+
+// AggregateDNSQueries handles a request to summarize the recent DNS queries per record, or per
+// resolver with by=resolver. See findQueries for the filters.
+func AggregateDNSQueries(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		by := req.URL.Query().Get("by")
+		if by != "" && by != "record" && by != "resolver" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "by must be either record or resolver"})
+			return
+		}
+		entries, ok := findQueries(server, w, req, 0)
+		if !ok {
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, querylog.Summarize(entries, by == "resolver"))
+	}
+}
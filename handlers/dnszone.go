@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// zoneSOA returns the SOA record of zone.
+func zoneSOA(zone *models.Zone) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone.Name), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: zone.TTL},
+		Ns:      dns.Fqdn(zone.NameServers[0].Name),
+		Mbox:    dns.Fqdn(zone.Mbox),
+		Serial:  zone.Serial,
+		Refresh: zone.Refresh,
+		Retry:   zone.Retry,
+		Expire:  zone.Expire,
+		Minttl:  zone.Minimum,
+	}
+}
+
+// zoneNS returns the NS records of zone.
+func zoneNS(zone *models.Zone) []dns.RR {
+	rrs := []dns.RR{}
+	for _, ns := range zone.NameServers {
+		rrs = append(rrs, &dns.NS{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(zone.Name), Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: zone.TTL},
+			Ns:  dns.Fqdn(ns.Name),
+		})
+	}
+	return rrs
+}
+
+// zoneGlue returns the address records of ns of type qtype, or of both types for dns.TypeANY.
+// Only name servers inside zone have glue.
+func zoneGlue(zone *models.Zone, ns models.NameServer, qtype uint16) []dns.RR {
+	rrs := []dns.RR{}
+	name := models.CanonicalName(ns.Name)
+	if !models.InZone(name, zone.Name) {
+		return rrs
+	}
+	hdr := dns.RR_Header{Name: dns.Fqdn(name), Class: dns.ClassINET, Ttl: zone.TTL}
+	if qtype == dns.TypeA || qtype == dns.TypeANY {
+		for _, a := range ns.A {
+			hdr.Rrtype = dns.TypeA
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: net.ParseIP(a)})
+		}
+	}
+	if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+		for _, a := range ns.AAAA {
+			hdr.Rrtype = dns.TypeAAAA
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(a)})
+		}
+	}
+	return rrs
+}
+
+// answersZone returns true if name, a canonical query name, is answered from zone rather than
+// proxied: the apex and the names of in-zone name servers.
+func answersZone(zone *models.Zone, name string) bool {
+	if name == zone.Name {
+		return true
+	}
+	return models.InZone(name, zone.Name) && zone.NameServer(name).Name != ""
+}
+
+// answerZone responds authoritatively to req for zone. The apex answers its SOA and NS records,
+// in-zone name servers answer their glue, names with no data of the requested type get an
+// empty answer and every other name does not exist.
+func answerZone(w dns.ResponseWriter, req *dns.Msg, zone *models.Zone) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	q := req.Question[0]
+	name := models.CanonicalName(q.Name)
+	switch {
+	case name == zone.Name:
+		switch q.Qtype {
+		case dns.TypeSOA:
+			m.Answer = []dns.RR{zoneSOA(zone)}
+		case dns.TypeNS:
+			m.Answer = zoneNS(zone)
+			for _, ns := range zone.NameServers {
+				m.Extra = append(m.Extra, zoneGlue(zone, ns, dns.TypeANY)...)
+			}
+		}
+	case answersZone(zone, name):
+		m.Answer = zoneGlue(zone, zone.NameServer(name), q.Qtype)
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+	if len(m.Answer) == 0 {
+		m.Ns = []dns.RR{zoneSOA(zone)}
+	}
+	w.WriteMsg(m)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+var testZone = &models.Zone{
+	ID:   "zone",
+	Name: "example.com",
+	NameServers: []models.NameServer{
+		{Name: "ns1.example.com", A: []string{"192.0.2.1"}, AAAA: []string{"2001:db8::1"}},
+		{Name: "ns2.example.net"},
+	},
+	Mbox:    "hostmaster.example.com",
+	Serial:  2024010101,
+	TTL:     3600,
+	Refresh: 7200,
+	Retry:   900,
+	Expire:  1209600,
+	Minimum: 300,
+}
+
+const (
+	testSOA  = "example.com.\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 2024010101 7200 900 1209600 300"
+	testNS1  = "example.com.\t3600\tIN\tNS\tns1.example.com."
+	testNS2  = "example.com.\t3600\tIN\tNS\tns2.example.net."
+	testA    = "ns1.example.com.\t3600\tIN\tA\t192.0.2.1"
+	testAAAA = "ns1.example.com.\t3600\tIN\tAAAA\t2001:db8::1"
+)
+
+func TestAnswersZone(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"example.com", true},
+		{"ns1.example.com", true},
+		{"ns2.example.net", false},
+		{"www.example.com", false},
+		{"x.ns1.example.com", false},
+		{"example.org", false},
+	}
+	for _, tt := range tests {
+		if got := answersZone(testZone, tt.name); got != tt.want {
+			t.Errorf("answersZone(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnswerZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		qtype  uint16
+		rcode  int
+		answer []string
+		ns     []string
+		extra  []string
+	}{
+		{"example.com", dns.TypeSOA, dns.RcodeSuccess, []string{testSOA}, []string{}, []string{}},
+		{"EXAMPLE.com", dns.TypeNS, dns.RcodeSuccess, []string{testNS1, testNS2}, []string{}, []string{testA, testAAAA}},
+		{"example.com", dns.TypeA, dns.RcodeSuccess, []string{}, []string{testSOA}, []string{}},
+		{"ns1.example.com", dns.TypeA, dns.RcodeSuccess, []string{testA}, []string{}, []string{}},
+		{"ns1.example.com", dns.TypeAAAA, dns.RcodeSuccess, []string{testAAAA}, []string{}, []string{}},
+		{"ns1.example.com", dns.TypeTXT, dns.RcodeSuccess, []string{}, []string{testSOA}, []string{}},
+		{"www.example.com", dns.TypeA, dns.RcodeNameError, []string{}, []string{testSOA}, []string{}},
+	}
+	for _, tt := range tests {
+		w := &testWriter{}
+		answerZone(w, question(tt.name, tt.qtype), testZone)
+		m := w.msg
+		if m == nil {
+			t.Fatalf("%s %s: no response", tt.name, dns.TypeToString[tt.qtype])
+		}
+		if !m.Authoritative || m.Rcode != tt.rcode {
+			t.Errorf("%s %s: authoritative %v rcode %d, want true %d", tt.name, dns.TypeToString[tt.qtype], m.Authoritative, m.Rcode, tt.rcode)
+		}
+		for _, section := range []struct {
+			name      string
+			got, want []string
+		}{
+			{"answer", rrStrings(m.Answer), tt.answer},
+			{"authority", rrStrings(m.Ns), tt.ns},
+			{"additional", rrStrings(m.Extra), tt.extra},
+		} {
+			if !reflect.DeepEqual(section.got, section.want) {
+				t.Errorf("%s %s: %s = %q, want %q", tt.name, dns.TypeToString[tt.qtype], section.name, section.got, section.want)
+			}
+		}
+	}
+}
+
+func TestZoneGlueOutOfZone(t *testing.T) {
+	ns := models.NameServer{Name: "ns.example.net", A: []string{"192.0.2.2"}}
+	if rrs := zoneGlue(testZone, ns, dns.TypeANY); len(rrs) != 0 {
+		t.Errorf("glue for a name server outside the zone: %v", rrs)
+	}
+}
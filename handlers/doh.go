@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/app"
+)
+
+// dohContentType is the media type of DNS wire format messages, RFC 8484.
+const dohContentType = "application/dns-message"
+
+// dohResponseWriter adapts an HTTP response to a dns.ResponseWriter so that DNS over HTTPS
+// queries are handled by ProxyDNS. Queries appear to come over TCP as there is no size limit.
+type dohResponseWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    []byte
+	closed bool
+}
+
+func (d *dohResponseWriter) LocalAddr() net.Addr  { return d.local }
+func (d *dohResponseWriter) RemoteAddr() net.Addr { return d.remote }
+func (d *dohResponseWriter) TsigStatus() error    { return nil }
+func (d *dohResponseWriter) TsigTimersOnly(bool)  {}
+func (d *dohResponseWriter) Hijack()              {}
+
+func (d *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = d.Write(data)
+	return err
+}
+
+func (d *dohResponseWriter) Write(data []byte) (int, error) {
+	d.msg = data
+	return len(data), nil
+}
+
+func (d *dohResponseWriter) Close() error {
+	d.closed = true
+	return nil
+}
+
+// tcpAddr parses addr, a host:port, into a *net.TCPAddr.
+func tcpAddr(addr string) net.Addr {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+// minTTL returns the lowest TTL of the records in m, used for the Cache-Control of a response.
+func minTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
+
+// dohQuery decodes the query of a DNS over HTTPS request, the dns parameter of a GET or the body
+// of a POST. If the request does not carry a valid query the status to respond with is returned.
+func dohQuery(w http.ResponseWriter, req *http.Request) (*dns.Msg, int) {
+	var data []byte
+	switch req.Method {
+	case "GET":
+		var err error
+		data, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		if err != nil || len(data) == 0 {
+			return nil, http.StatusBadRequest
+		}
+	case "POST":
+		if req.Header.Get("Content-Type") != dohContentType {
+			return nil, http.StatusUnsupportedMediaType
+		}
+		var err error
+		data, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, dns.MaxMsgSize))
+		if err != nil {
+			return nil, http.StatusRequestEntityTooLarge
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		return nil, http.StatusMethodNotAllowed
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(data); err != nil {
+		return nil, http.StatusBadRequest
+	}
+	return query, http.StatusOK
+}
+
+// DoH returns a handler for DNS over HTTPS queries, RFC 8484. Queries are accepted as the dns
+// parameter of a GET or the body of a POST and routed by ProxyDNS.
+func DoH(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	handler := ProxyDNS(server)
+	return func(w http.ResponseWriter, req *http.Request) {
+		query, status := dohQuery(w, req)
+		if query == nil {
+			server.Render.Data(w, status, nil)
+			return
+		}
+
+		local := &net.TCPAddr{}
+		if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			local = tcpAddr(addr.String()).(*net.TCPAddr)
+		}
+		dw := &dohResponseWriter{local: local, remote: tcpAddr(req.RemoteAddr)}
+		handler(dw, query)
+
+		if dw.msg == nil {
+			// The query was dropped, close the connection without a response.
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			server.Render.Data(w, http.StatusBadGateway, nil)
+			return
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(dw.msg); err == nil {
+			w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minTTL(resp))))
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(dw.msg)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoHQuery(t *testing.T) {
+	query := question("www.example.com", dns.TypeTXT)
+	query.Id = 0
+	wire, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(wire)
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"get", "GET", "/dns-query?dns=" + encoded, "", nil, http.StatusOK},
+		{"get missing", "GET", "/dns-query", "", nil, http.StatusBadRequest},
+		{"get not base64", "GET", "/dns-query?dns=!!!", "", nil, http.StatusBadRequest},
+		{"get not dns", "GET", "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3}), "", nil, http.StatusBadRequest},
+		{"post", "POST", "/dns-query", dohContentType, wire, http.StatusOK},
+		{"post content type", "POST", "/dns-query", "application/json", wire, http.StatusUnsupportedMediaType},
+		{"post too large", "POST", "/dns-query", dohContentType, make([]byte, dns.MaxMsgSize+1), http.StatusRequestEntityTooLarge},
+		{"post not dns", "POST", "/dns-query", dohContentType, []byte{1, 2, 3}, http.StatusBadRequest},
+		{"put", "PUT", "/dns-query", dohContentType, wire, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, bytes.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		got, status := dohQuery(w, req)
+		if status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			if got != nil {
+				t.Errorf("%s: query decoded from an invalid request", tt.name)
+			}
+			continue
+		}
+		if len(got.Question) != 1 || got.Question[0].Name != "www.example.com." || got.Question[0].Qtype != dns.TypeTXT {
+			t.Errorf("%s: query = %v", tt.name, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	dohQuery(w, httptest.NewRequest("DELETE", "/dns-query", nil))
+	if allow := w.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow = %q, want GET, POST", allow)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	m := new(dns.Msg)
+	if ttl := minTTL(m); ttl != 0 {
+		t.Errorf("minTTL of an empty message = %d, want 0", ttl)
+	}
+	m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.", Rrtype: dns.TypeA, Ttl: 300}}}
+	m.Ns = []dns.RR{zoneSOA(testZone)}
+	m.SetEdns0(4096, false)
+	if ttl := minTTL(m); ttl != 300 {
+		t.Errorf("minTTL = %d, want 300", ttl)
+	}
+}
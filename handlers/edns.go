@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// ednsWriter writes DNS responses following the EDNS0 options of the query. Responses carry an
+// OPT record advertising size only if the query had one, and responses too large for a UDP
+// client are truncated.
+type ednsWriter struct {
+	dns.ResponseWriter
+	req  *dns.Msg
+	size uint16
+}
+
+func (e *ednsWriter) WriteMsg(m *dns.Msg) error {
+	extra := []dns.RR{}
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+	limit := dns.MinMsgSize
+	if opt := e.req.IsEdns0(); opt != nil {
+		m.SetEdns0(e.size, opt.Do())
+		if client := int(opt.UDPSize()); client > limit {
+			limit = client
+		}
+		if limit > int(e.size) {
+			limit = int(e.size)
+		}
+	}
+	if _, ok := e.RemoteAddr().(*net.UDPAddr); ok && m.Len() > limit {
+		m.Truncated = true
+		m.Answer = nil
+		m.Ns = nil
+		m.Extra = nil
+		if opt := e.req.IsEdns0(); opt != nil {
+			m.SetEdns0(e.size, opt.Do())
+		}
+	}
+	return e.ResponseWriter.WriteMsg(m)
+}
+
+// forwardQuery returns a copy of req to send to the handler of record. The copy advertises the
+// buffer size of the record and, unless the record keeps it, has no client subnet option.
+func forwardQuery(req *dns.Msg, record *models.Record) *dns.Msg {
+	query := req.Copy()
+	opt := query.IsEdns0()
+	if opt == nil {
+		query.SetEdns0(record.EDNS.EDNSBufferSize(), false)
+		return query
+	}
+	opt.SetUDPSize(record.EDNS.EDNSBufferSize())
+	if record.EDNS.ClientSubnet {
+		return query
+	}
+	options := []dns.EDNS0{}
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0SUBNET {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+	return query
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// reply returns a response to req with A records until it is at least size bytes long. The
+// response carries an OPT record, as one from a handler would.
+func reply(req *dns.Msg, size int) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.SetEdns0(4096, false)
+	for i := 0; m.Len() < size; i++ {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+	return m
+}
+
+// ednsQuery returns a query that advertises size with EDNS0, or has no OPT record if size is 0.
+func ednsQuery(size uint16, do bool) *dns.Msg {
+	m := question("www.example.com", dns.TypeA)
+	if size != 0 {
+		m.SetEdns0(size, do)
+	}
+	return m
+}
+
+func TestEDNSWriter(t *testing.T) {
+	udp := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	tcp := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	tests := []struct {
+		name      string
+		req       *dns.Msg
+		size      uint16
+		remote    net.Addr
+		length    int
+		truncated bool
+		// opt is the size advertised in the response, 0 if it must not have an OPT record.
+		opt uint16
+		do  bool
+	}{
+		{"no edns", ednsQuery(0, false), 1232, udp, 100, false, 0, false},
+		{"no edns large", ednsQuery(0, false), 1232, udp, 600, true, 0, false},
+		{"no edns tcp", ednsQuery(0, false), 1232, tcp, 3000, false, 0, false},
+		{"edns", ednsQuery(4096, false), 1232, udp, 1000, false, 1232, false},
+		{"edns do", ednsQuery(4096, true), 1232, udp, 100, false, 1232, true},
+		{"edns over record size", ednsQuery(4096, false), 1232, udp, 1300, true, 1232, false},
+		{"edns over client size", ednsQuery(700, false), 1232, udp, 800, true, 1232, false},
+		{"edns client below minimum", ednsQuery(256, false), 1232, udp, 500, false, 1232, false},
+		{"edns record size", ednsQuery(4096, false), 4000, udp, 3000, false, 4000, false},
+		{"edns tcp", ednsQuery(512, false), 1232, tcp, 3000, false, 1232, false},
+	}
+	for _, tt := range tests {
+		w := &testWriter{remote: tt.remote}
+		ew := &ednsWriter{ResponseWriter: w, req: tt.req, size: tt.size}
+		if err := ew.WriteMsg(reply(tt.req, tt.length)); err != nil {
+			t.Fatal(err)
+		}
+		m := w.msg
+		if m.Truncated != tt.truncated {
+			t.Errorf("%s: truncated = %v, want %v", tt.name, m.Truncated, tt.truncated)
+		}
+		if m.Truncated && len(m.Answer)+len(m.Ns) != 0 {
+			t.Errorf("%s: truncated response kept its records", tt.name)
+		}
+		if !m.Truncated && len(m.Answer) == 0 {
+			t.Errorf("%s: response lost its answers", tt.name)
+		}
+		opts := 0
+		for _, rr := range m.Extra {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				opts++
+			}
+		}
+		opt := m.IsEdns0()
+		switch {
+		case tt.opt == 0 && opts != 0:
+			t.Errorf("%s: response has an OPT record the query did not", tt.name)
+		case tt.opt != 0 && (opts != 1 || opt.UDPSize() != tt.opt || opt.Do() != tt.do):
+			t.Errorf("%s: response has %d OPT records %v, want one advertising %d with DO %v", tt.name, opts, opt, tt.opt, tt.do)
+		}
+	}
+}
+
+// subnetQuery returns a query with a client subnet option and a cookie.
+func subnetQuery() *dns.Msg {
+	m := ednsQuery(4096, true)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.IPv4(198, 51, 100, 0)},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0123456789abcdef"},
+	)
+	return m
+}
+
+// optionCodes returns the codes of the EDNS0 options of m.
+func optionCodes(m *dns.Msg) []uint16 {
+	codes := []uint16{}
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			codes = append(codes, o.Option())
+		}
+	}
+	return codes
+}
+
+func TestForwardQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *dns.Msg
+		edns    models.EDNS
+		size    uint16
+		do      bool
+		options []uint16
+	}{
+		{"no edns", ednsQuery(0, false), models.EDNS{}, models.DefaultEDNSBufferSize, false, []uint16{}},
+		{"record size", ednsQuery(512, true), models.EDNS{BufferSize: 4000}, 4000, true, []uint16{}},
+		{"strip subnet", subnetQuery(), models.EDNS{}, models.DefaultEDNSBufferSize, true, []uint16{dns.EDNS0COOKIE}},
+		{"keep subnet", subnetQuery(), models.EDNS{ClientSubnet: true}, models.DefaultEDNSBufferSize, true, []uint16{dns.EDNS0SUBNET, dns.EDNS0COOKIE}},
+	}
+	for _, tt := range tests {
+		before := optionCodes(tt.req)
+		query := forwardQuery(tt.req, &models.Record{EDNS: tt.edns})
+		opt := query.IsEdns0()
+		if opt == nil {
+			t.Errorf("%s: forwarded query has no OPT record", tt.name)
+			continue
+		}
+		if opt.UDPSize() != tt.size || opt.Do() != tt.do {
+			t.Errorf("%s: forwarded size %d DO %v, want %d %v", tt.name, opt.UDPSize(), opt.Do(), tt.size, tt.do)
+		}
+		if got := optionCodes(query); !reflect.DeepEqual(got, tt.options) {
+			t.Errorf("%s: forwarded options %v, want %v", tt.name, got, tt.options)
+		}
+		if after := optionCodes(tt.req); len(after) != len(before) {
+			t.Errorf("%s: the client's query was modified", tt.name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// haltAction returns the audit action for a change to the kill switch.
+func haltAction(halted bool) string {
+	if halted {
+		return "halt"
+	}
+	return "resume"
+}
+
+// setHalt returns an HTTP handler that engages or releases the kill switch.
+func setHalt(server *app.App, halted bool) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user := currentUser(req)
+		before, settings, err := models.SetHalted(server.DB, halted, user.Email)
+		// The switch is applied even if it could not be saved, stopping traffic comes first.
+		server.SetHalted(halted)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving settings to the database, the change will not survive a restart"})
+			log.Println(err)
+			return
+		}
+		log.Printf("%s by %s", haltAction(halted), user.Email)
+		audit(server, req, user, haltAction(halted), "settings", settings.ID, before, settings)
+		server.Render.JSON(w, http.StatusOK, settings)
+	}
+}
+
+// Halt handles a request to engage the kill switch. Every proxy stops routing traffic and
+// serves only its fallback response until Resume is called, including across restarts.
+func Halt(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	handler := setHalt(server, true)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// Resume handles a request to release the kill switch.
+func Resume(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	handler := setHalt(server, false)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// HaltSignal engages or releases the kill switch in response to the signal named sig.
+func HaltSignal(server *app.App, halted bool, sig string) {
+	by := "signal " + sig
+	before, settings, err := models.SetHalted(server.DB, halted, by)
+	server.SetHalted(halted)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("%s by %s", haltAction(halted), by)
+	b, a, err := models.AuditDiff(before, settings)
+	if err != nil {
+		log.Println(err)
+	}
+	entry := &models.AuditEntry{
+		Actor:      by,
+		Action:     haltAction(halted),
+		TargetType: "settings",
+		TargetID:   settings.ID,
+		Before:     b,
+		After:      a,
+	}
+	if err := models.AppendAudit(server.DB, entry); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// loadHistoryRecord looks up the record provided by the mux parameter id, or the record as it
+// was deleted if it no longer exists, writing a not found response if neither is found or the
+// record is not visible to the current user.
+func loadHistoryRecord(server *app.App, w http.ResponseWriter, req *http.Request) (*models.Record, bool) {
+	id := mux.Vars(req)["id"]
+	record := &models.Record{ID: id}
+	if ok, err := server.DB.Exists(record); err == nil && ok {
+		return loadVisibleRecord(server, w, req)
+	}
+	record, err := models.FindDeletedRecord(server.DB, id)
+	if err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record history from the database"})
+		log.Println(err)
+		return record, false
+	}
+	if record.ID == "" {
+		server.Render.JSON(w, http.StatusNotFound, nil)
+		return record, false
+	}
+	return record, checkVisible(server, w, req, record)
+}
+
+// ShowRecordHistory handles a request to return every version of a record provided by the
+// mux parameter id, including records that have been deleted.
+func ShowRecordHistory(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		record, ok := loadHistoryRecord(server, w, req)
+		if !ok {
+			return
+		}
+		versions, err := models.FindRecordHistory(server.DB, record.ID)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record history from the database"})
+			log.Println(err)
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, versions)
+	}
+}
+
+// RollbackRecord handles a request to restore a previous version of a record provided by the
+// mux parameter id. The restored settings are saved as a new version, a deleted record is
+// recreated with them. If the version is no longer valid, for example because its owner was
+// removed or a zone now answers its FQDN, the response is a conflict.
+func RollbackRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
+		rollbackReq := &models.RollbackRequest{}
+		if err := binding.Bind(req, rollbackReq); err.Handle(w) {
+			return
+		}
+		record, ok := loadHistoryRecord(server, w, req)
+		if !ok {
+			return
+		}
+		if !record.CanModify(currentUser(req)) {
+			forbidRecord(server, w)
+			return
+		}
+		version, err := models.FindRecordVersion(server.DB, record.ID, rollbackReq.Version)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record history from the database"})
+			log.Println(err)
+			return
+		}
+		if version.ID == "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "version does not exist"})
+			return
+		}
+		updateReq, err := models.UpdateRecordRequestFrom(&version.Record)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the record"})
+			log.Println(err)
+			return
+		}
+		if errs := updateReq.Validate(req, nil); len(errs) > 0 {
+			server.Render.JSON(w, http.StatusConflict, map[string]string{"error": "version can no longer be restored: " + errs[0].Message})
+			return
+		}
+		updateRecord(server, w, req, record, updateReq, "rollback")
+	}
+}
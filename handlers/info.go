@@ -9,6 +9,7 @@ import (
 // Infos struct holds version and proxy info.
 type Infos struct {
 	Version string `json:"version"`
+	Halted  bool   `json:"halted"`
 	Proxy   struct {
 		DNS struct {
 			Enabled  bool   `json:"enabled"`
@@ -30,6 +31,7 @@ func Info(server *app.App, version string) func(w http.ResponseWriter, req *http
 	return func(w http.ResponseWriter, req *http.Request) {
 		info := &Infos{}
 		info.Version = version
+		info.Halted = server.Halted()
 		info.Proxy.SSL.Enabled = server.Config.Proxy.SSL.Enabled
 		info.Proxy.SSL.Listener = server.Config.Proxy.SSL.Listener
 		info.Proxy.HTTP.Enabled = server.Config.Proxy.HTTP.Enabled
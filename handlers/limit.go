@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// admit checks a request from ip against the rate limits and connection caps of record. If the
+// request is allowed it is counted and a function is returned that must be called once the
+// request has finished. If it is over a limit the returned function is nil.
+func admit(server *app.App, record *models.Record, ip string) func() {
+	limits := record.Limits
+	recordKey := record.ID
+	ipKey := record.ID + "|" + ip
+	// The limits of the client are checked first, so a single client over its own limits does
+	// not use up the limits of the record shared by every client.
+	if !server.RateLimits.Allow(ipKey, limits.IPRate, limits.IPBurst) || !server.RateLimits.Allow(recordKey, limits.Rate, limits.Burst) {
+		server.Stats.RateLimited(record.ID)
+		return nil
+	}
+	if !server.Connections.Acquire(ipKey, limits.IPConnections) {
+		server.Stats.ConnectionLimited(record.ID)
+		return nil
+	}
+	if !server.Connections.Acquire(recordKey, limits.Connections) {
+		server.Connections.Release(ipKey)
+		server.Stats.ConnectionLimited(record.ID)
+		return nil
+	}
+	server.Stats.Request(record.ID)
+	return func() {
+		server.Connections.Release(recordKey)
+		server.Connections.Release(ipKey)
+	}
+}
+
+// ShowRecordStats handles a request to return the traffic counters of a record provided by the
+// mux parameter id. Counters are kept in memory and start from zero when the server starts.
+func ShowRecordStats(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
+			return
+		}
+		id := record.ID
+		counters := server.Stats.Get(id)
+		counters.ActiveConnections = server.Connections.Count(id)
+		server.Render.JSON(w, http.StatusOK, counters)
+	}
+}
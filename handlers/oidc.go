@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jmcvetta/randutil"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/auth"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// oidcCookie holds the state, nonce and PKCE verifier of a login in progress.
+const oidcCookie = "shellsquid_oidc"
+
+// oidcLoginTTL is the number of seconds a user has to complete a login at the provider.
+const oidcLoginTTL = 600
+
+// parseOIDCState validates the signed login state stored in the cookie.
+func parseOIDCState(server *app.App, tokenString string) (map[string]interface{}, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		if typ, _ := token.Claims["typ"].(string); typ != "oidc_state" {
+			return nil, errors.New("token is not an oidc state")
+		}
+		return server.JWTSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims, nil
+}
+
+// OIDCLogin returns an HTTP handler that redirects the user to the identity provider to log in.
+func OIDCLogin(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if server.OIDC == nil {
+			server.Render.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		state, err := randutil.AlphaString(32)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error starting login"})
+			return
+		}
+		nonce, err := randutil.AlphaString(32)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error starting login"})
+			return
+		}
+		verifier, err := randutil.AlphaString(64)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error starting login"})
+			return
+		}
+		sum := sha256.Sum256([]byte(verifier))
+		redirect, err := server.OIDC.AuthCodeURL(state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusServiceUnavailable, map[string]string{"error": "error contacting identity provider"})
+			return
+		}
+		token := jwt.New(jwt.SigningMethodHS256)
+		token.Claims["typ"] = "oidc_state"
+		token.Claims["state"] = state
+		token.Claims["nonce"] = nonce
+		token.Claims["verifier"] = verifier
+		token.Claims["exp"] = time.Now().Unix() + oidcLoginTTL
+		tokenString, err := token.SignedString(server.JWTSecret)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error starting login"})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookie,
+			Value:    tokenString,
+			Path:     "/api/oidc",
+			MaxAge:   oidcLoginTTL,
+			Secure:   true,
+			HttpOnly: true,
+		})
+		http.Redirect(w, req, redirect, http.StatusFound)
+	}
+}
+
+// OIDCCallback returns an HTTP handler for the identity provider to redirect to after a login.
+// The authorization code is exchanged for an identity token, and tokens are issued for the user
+// it identifies.
+func OIDCCallback(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if server.OIDC == nil {
+			server.Render.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		query := req.URL.Query()
+		if e := query.Get("error"); e != "" {
+			server.Render.JSON(w, http.StatusUnauthorized, map[string]string{"error": "identity provider returned " + e})
+			return
+		}
+		cookie, err := req.Cookie(oidcCookie)
+		if err != nil {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "login has expired, try again"})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/api/oidc", MaxAge: -1, Secure: true, HttpOnly: true})
+		claims, err := parseOIDCState(server, cookie.Value)
+		if err != nil {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "login has expired, try again"})
+			return
+		}
+		state, _ := claims["state"].(string)
+		nonce, _ := claims["nonce"].(string)
+		verifier, _ := claims["verifier"].(string)
+		if state == "" || query.Get("state") != state {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "invalid login state"})
+			return
+		}
+		code := query.Get("code")
+		if code == "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "code is required"})
+			return
+		}
+		identity, err := server.OIDC.Exchange(code, verifier, nonce)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusUnauthorized, map[string]string{"error": "error verifying identity"})
+			return
+		}
+		user, err := server.OIDC.User(server.DB, identity)
+		if err == auth.ErrOIDCIdentity {
+			email, _ := identity["email"].(string)
+			audit(server, req, &models.User{Email: email}, "login.failed", "user", "", nil, nil)
+			server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error getting user from database"})
+			return
+		}
+		audit(server, req, user, "login", "user", user.ID, nil, nil)
+		if redirect := server.Config.OIDC.PostLoginRedirect; redirect != "" {
+			accessToken, refreshToken, exp, err := newTokens(server, user)
+			if err != nil {
+				log.Println(err)
+				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error signing token"})
+				return
+			}
+			v := url.Values{}
+			v.Set("token", accessToken)
+			v.Set("refresh_token", refreshToken)
+			v.Set("expires_at", strconv.FormatInt(exp, 10))
+			http.Redirect(w, req, redirect+"#"+v.Encode(), http.StatusFound)
+			return
+		}
+		issueTokens(server, w, user)
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// checkProject returns an HTTP status and error message if user may not add records to the
+// project provided by id. An empty id is always allowed.
+func checkProject(server *app.App, user *models.User, id string) (int, string) {
+	if id == "" {
+		return http.StatusOK, ""
+	}
+	project, err := models.FindProjectByID(server.DB, id)
+	if err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError, "there was an error getting project from the database"
+	}
+	if project.ID == "" {
+		return http.StatusBadRequest, "project does not exist"
+	}
+	if !project.HasMember(user) {
+		return http.StatusForbidden, "you are not a member of this project"
+	}
+	if project.Archived {
+		return http.StatusBadRequest, "project has been archived"
+	}
+	return http.StatusOK, ""
+}
+
+// findProject looks up the project provided by the mux parameter id, writing a not found
+// response if it does not exist or is not visible to the current user.
+func findProject(server *app.App, w http.ResponseWriter, req *http.Request) (*models.Project, bool) {
+	vars := mux.Vars(req)
+	project, err := models.FindProjectByID(server.DB, vars["id"])
+	if err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting project from the database"})
+		log.Println(err)
+		return project, false
+	}
+	if project.ID == "" || !project.HasMember(currentUser(req)) {
+		server.Render.JSON(w, http.StatusNotFound, nil)
+		return project, false
+	}
+	return project, true
+}
+
+// CreateProject handles a request to create a new project.
+func CreateProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		projectReq := &models.ProjectRequest{}
+		if err := binding.Bind(req, projectReq); err.Handle(w) {
+			return
+		}
+		existing, err := models.FindProjectByName(server.DB, projectReq.Name)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the project to the database"})
+			log.Println(err)
+			return
+		}
+		if existing.ID != "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "project name must be unique across the application"})
+			return
+		}
+		if msg := validateUsers(server, "members", projectReq.Members); msg != "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		if projectReq.Members == nil {
+			projectReq.Members = []string{}
+		}
+		now := time.Now().Unix()
+		project := &models.Project{
+			Name:        projectReq.Name,
+			Description: projectReq.Description,
+			Members:     projectReq.Members,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := server.DB.Save(project); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the project to the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "project.create", "project", project.ID, nil, project)
+		server.Render.JSON(w, http.StatusCreated, project)
+	}
+}
+
+// IndexProject handles a request to return a list of the projects the user is a member of.
+func IndexProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		projects, err := models.FindProjectsForUser(server.DB, currentUser(req))
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting projects from the database"})
+			log.Println(err)
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, projects)
+	}
+}
+
+// ShowProject handles a request to return a single project provided by the mux parameter id.
+func ShowProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		project, ok := findProject(server, w, req)
+		if !ok {
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, project)
+	}
+}
+
+// UpdateProject handles a request to update a single project provided by the mux parameter id.
+func UpdateProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		project, ok := findProject(server, w, req)
+		if !ok {
+			return
+		}
+		projectReq := &models.ProjectRequest{}
+		if err := binding.Bind(req, projectReq); err.Handle(w) {
+			return
+		}
+		if projectReq.Name != project.Name {
+			existing, err := models.FindProjectByName(server.DB, projectReq.Name)
+			if err != nil {
+				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the project"})
+				log.Println(err)
+				return
+			}
+			if existing.ID != "" {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "project name must be unique across the application"})
+				return
+			}
+		}
+		if msg := validateUsers(server, "members", projectReq.Members); msg != "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		if projectReq.Members == nil {
+			projectReq.Members = []string{}
+		}
+		before := *project
+		project.Name = projectReq.Name
+		project.Description = projectReq.Description
+		project.Members = projectReq.Members
+		project.UpdatedAt = time.Now().Unix()
+		if err := server.DB.Save(project); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the project"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "project.update", "project", project.ID, &before, project)
+		server.Render.JSON(w, http.StatusOK, project)
+	}
+}
+
+// DeleteProject handles a request to delete a single project provided by the mux parameter id.
+func DeleteProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		project, ok := findProject(server, w, req)
+		if !ok {
+			return
+		}
+		if foundRecords, err := models.FindRecordsForProject(server.DB, project.ID); err != nil || len(foundRecords) > 0 {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "this project has records, remove or reassign before deleting"})
+			return
+		}
+		if err := server.DB.Delete(project); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error deleting the project from the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "project.delete", "project", project.ID, project, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// ArchiveProject handles a request to archive a project provided by the mux parameter id. Every
+// record in the project is blacklisted so that no further traffic is routed to its handlers.
+func ArchiveProject(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		project, ok := findProject(server, w, req)
+		if !ok {
+			return
+		}
+		records, err := models.FindRecordsForProject(server.DB, project.ID)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting records from the database"})
+			log.Println(err)
+			return
+		}
+		now := time.Now().Unix()
+		for i := range records {
+			if records[i].Blacklist {
+				continue
+			}
+			before := records[i]
+			if err := server.DB.Update(&records[i], map[string]interface{}{"Blacklist": true, "UpdatedAt": now, "Version": before.Version + 1}); err != nil {
+				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the project records"})
+				log.Println(err)
+				return
+			}
+			if err := models.AddRecordVersion(server.DB, &records[i], &before, currentUser(req), "archive"); err != nil {
+				log.Println(err)
+			}
+			audit(server, req, currentUser(req), "record.blacklist", "record", records[i].ID, &before, &records[i])
+		}
+		project.Archived = true
+		project.UpdatedAt = now
+		if err := server.DB.Save(project); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the project"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "project.archive", "project", project.ID, nil, nil)
+		server.Render.JSON(w, http.StatusOK, project)
+	}
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/tomsteele/shellsquid/app"
@@ -19,6 +23,98 @@ func hostname(host string) string {
 	return parts[0]
 }
 
+// captureDNS writes msg to the capture file for record.
+func captureDNS(server *app.App, record *models.Record, src, dst net.Addr, msg *dns.Msg) {
+	data, err := msg.Pack()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := server.Captures.Write(record.ID, src, dst, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// overLimitDNS responds to a DNS query that is over one of the limits of record.
+func overLimitDNS(server *app.App, w dns.ResponseWriter, req *dns.Msg, record *models.Record, zone *models.Zone) {
+	switch record.Limits.OverLimitAction() {
+	case models.LimitDrop:
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			w.Close()
+		}
+	case models.LimitFallback:
+		noRecordDNS(server, w, req, zone)
+	default:
+		refuseDNS(w, req)
+	}
+}
+
+// refuseDNS responds to req with REFUSED.
+func refuseDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	w.WriteMsg(m)
+}
+
+// overLimitHTTP responds to an HTTP request that is over one of the limits of record.
+func overLimitHTTP(server *app.App, w http.ResponseWriter, record *models.Record) {
+	switch record.Limits.OverLimitAction() {
+	case models.LimitDrop:
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		server.Render.Data(w, http.StatusNotFound, nil)
+	case models.LimitFallback:
+		server.Render.Data(w, http.StatusNotFound, nil)
+	default:
+		w.Header().Set("Retry-After", "1")
+		server.Render.Data(w, http.StatusTooManyRequests, nil)
+	}
+}
+
+// upstreamBackoff is the wait before the second attempt to reach the handlers of a record, it
+// doubles for each further attempt.
+const upstreamBackoff = 50 * time.Millisecond
+
+// exchangeDNS sends req to the handler of record and its fallbacks following the upstream
+// options of the record, returning the first response. A truncated UDP response is retried over
+// TCP, and returned as it is if the retry fails. If no handler responds the error lists the
+// failure of each attempt.
+func exchangeDNS(req *dns.Msg, record *models.Record, transport string) (*dns.Msg, error) {
+	upstream := record.Upstream
+	timeout := time.Duration(upstream.UpstreamTimeout()) * time.Millisecond
+	c := &dns.Client{Net: transport, DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	tcp := &dns.Client{Net: "tcp", DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	addrs := append([]string{net.JoinHostPort(record.HandlerHost, strconv.Itoa(record.HandlerPort))}, upstream.Fallbacks...)
+	failures := []string{}
+	for attempt := 0; attempt <= upstream.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(upstreamBackoff << uint(attempt-1))
+		}
+		for _, addr := range addrs {
+			resp, _, err := c.Exchange(req, addr)
+			if err != nil {
+				failures = append(failures, addr+": "+err.Error())
+				continue
+			}
+			if resp.Truncated && transport == "udp" {
+				full, _, err := tcp.Exchange(req, addr)
+				if err != nil {
+					// The truncated answer tells the client to retry over TCP itself.
+					log.Println(err)
+					return resp, nil
+				}
+				resp = full
+			}
+			return resp, nil
+		}
+	}
+	return nil, errors.New("no dns handler of " + record.FQDN + " responded: " + strings.Join(failures, ", "))
+}
+
 // ProxyDNS returns a handler for a proxy DNS server.
 func ProxyDNS(server *app.App) func(w dns.ResponseWriter, req *dns.Msg) {
 	return func(w dns.ResponseWriter, req *dns.Msg) {
@@ -26,26 +122,90 @@ func ProxyDNS(server *app.App) func(w dns.ResponseWriter, req *dns.Msg) {
 			dns.HandleFailed(w, req)
 			return
 		}
+		start := time.Now()
+		ew := &ednsWriter{ResponseWriter: w, req: req, size: models.DefaultEDNSBufferSize}
+		qw := &queryWriter{ResponseWriter: ew}
+		w = qw
+		record := &models.Record{}
+		defer func() {
+			logQuery(server, qw, req, record, start)
+		}()
+		switch req.Question[0].Qtype {
+		case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR:
+			refuseDNS(w, req)
+			return
+		}
+		if server.Halted() {
+			failDNS(server, w, req, nil, &models.Zone{})
+			return
+		}
 		name := req.Question[0].Name
-		record, err := models.FindRecordBySubOfFQDN(server.DB, name)
+		zone, err := models.FindZoneForName(server.DB, name)
+		if err != nil {
+			log.Println(err)
+		}
+		if zone.ID != "" && answersZone(zone, models.CanonicalName(name)) {
+			answerZone(w, req, zone)
+			return
+		}
+		record, static, err := models.FindRecordForQuery(server.DB, name, zone)
 		if err != nil || record.ID == "" {
-			dns.HandleFailed(w, req)
+			noRecordDNS(server, w, req, zone)
 			return
 		}
-		if record.Blacklist {
-			dns.HandleFailed(w, req)
+		if record.Blacklist || !record.Active(time.Now()) {
+			failDNS(server, w, req, record, zone)
+			return
+		}
+		ew.size = record.EDNS.EDNSBufferSize()
+		if !record.AllowsType(req.Question[0].Qtype) {
+			refuseDNS(w, req)
+			return
+		}
+		ip, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		release := admit(server, record, ip)
+		if release == nil {
+			overLimitDNS(server, w, req, record, zone)
+			return
+		}
+		defer release()
+		if static {
+			resp := staticAnswer(req, record.Answers, zone)
+			if record.Capture {
+				captureDNS(server, record, w.RemoteAddr(), w.LocalAddr(), req)
+				captureDNS(server, record, w.LocalAddr(), w.RemoteAddr(), resp)
+			}
+			w.WriteMsg(resp)
 			return
 		}
 		transport := "udp"
 		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
 			transport = "tcp"
 		}
-		c := &dns.Client{Net: transport}
-		resp, _, err := c.Exchange(req, record.HandlerHost+":"+strconv.Itoa(record.HandlerPort))
+		if record.Capture {
+			captureDNS(server, record, w.RemoteAddr(), w.LocalAddr(), req)
+		}
+		query := forwardQuery(req, record)
+		if record.RewriteTo != "" {
+			query.Question[0].Name = rewriteName(query.Question[0].Name, record.FQDN, record.RewriteTo)
+		}
+		resp, err := exchangeDNS(query, record, transport)
 		if err != nil {
+			log.Println(err)
 			dns.HandleFailed(w, req)
 			return
 		}
+		if server.Halted() {
+			// The kill switch was engaged while the handler was answering.
+			failDNS(server, w, req, record, zone)
+			return
+		}
+		if record.RewriteTo != "" {
+			restoreMsg(resp, req, record)
+		}
+		if record.Capture {
+			captureDNS(server, record, w.LocalAddr(), w.RemoteAddr(), resp)
+		}
 		if err := w.WriteMsg(resp); err != nil {
 			dns.HandleFailed(w, req)
 			return
@@ -56,15 +216,25 @@ func ProxyDNS(server *app.App) func(w dns.ResponseWriter, req *dns.Msg) {
 // Proxy returns a handler to proxy HTTP(S) requests.
 func Proxy(server *app.App, isHTTPS bool) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if server.Halted() {
+			server.Render.Data(w, http.StatusNotFound, nil)
+			return
+		}
 		record, err := models.FindRecordByFQDN(server.DB, hostname(req.Host))
 		if err != nil || record.ID == "" {
 			server.Render.Data(w, http.StatusNotFound, nil)
 			return
 		}
-		if record.Blacklist {
+		if record.Blacklist || record.HandlerProtocol == models.ProtocolStatic || !record.Active(time.Now()) {
 			server.Render.Data(w, http.StatusNotFound, nil)
 			return
 		}
+		release := admit(server, record, remoteIP(req))
+		if release == nil {
+			overLimitHTTP(server, w, record)
+			return
+		}
+		defer release()
 		u, err := url.Parse(record.HandlerProtocol + "://" + record.HandlerHost + ":" + strconv.Itoa(record.HandlerPort))
 		if err != nil {
 			server.Render.Data(w, http.StatusNotFound, nil)
@@ -76,6 +246,16 @@ func Proxy(server *app.App, isHTTPS bool) func(w http.ResponseWriter, req *http.
 				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 			}
 		}
-		proxy.ServeHTTP(w, req)
+		// Cancel the request, and any connection upgraded by it, if the kill switch is engaged.
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-server.HaltNotify():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		proxy.ServeHTTP(w, req.WithContext(ctx))
 	}
 }
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// testHandler is a DNS handler that counts the queries it receives over each transport. It
+// ignores the first drop queries, and answers over UDP with an empty truncated response if
+// truncate is set.
+type testHandler struct {
+	answer   string
+	drop     int
+	truncate bool
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func (h *testHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	transport := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		transport = "tcp"
+	}
+	h.mu.Lock()
+	h.queries[transport]++
+	n := h.queries["udp"] + h.queries["tcp"]
+	h.mu.Unlock()
+	if n <= h.drop {
+		return
+	}
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if h.truncate && transport == "udp" {
+		m.Truncated = true
+	} else {
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(h.answer),
+		}}
+	}
+	w.WriteMsg(m)
+}
+
+func (h *testHandler) count(transport string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.queries[transport]
+}
+
+// startDNS serves h over UDP, and over TCP on the same port if tcp is set, until the test ends.
+// It returns the address of the server.
+func startDNS(t *testing.T, h *testHandler, tcp bool) string {
+	h.queries = map[string]int{}
+	for i := 0; i < 10; i++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := pc.LocalAddr().String()
+		servers := []*dns.Server{{PacketConn: pc, Handler: h}}
+		if tcp {
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				// The port is taken over TCP, try another.
+				pc.Close()
+				continue
+			}
+			servers = append(servers, &dns.Server{Listener: l, Handler: h})
+		}
+		for _, s := range servers {
+			started := make(chan struct{})
+			s.NotifyStartedFunc = func() { close(started) }
+			go s.ActivateAndServe()
+			<-started
+			t.Cleanup(func() { s.Shutdown() })
+		}
+		return addr
+	}
+	t.Fatal("could not find a port free over UDP and TCP")
+	return ""
+}
+
+// closedAddr returns a local UDP address that nothing listens on.
+func closedAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+// testRecord returns a dns record routed to addr with upstream.
+func testRecord(t *testing.T, addr string, upstream models.Upstream) *models.Record {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &models.Record{FQDN: "c2.example.org", HandlerHost: host, HandlerProtocol: "dns", Upstream: upstream}
+	record.HandlerPort, _ = net.LookupPort("udp", port)
+	return record
+}
+
+// answerOf returns the address answered in m, or an empty string.
+func answerOf(m *dns.Msg) string {
+	if m == nil || len(m.Answer) == 0 {
+		return ""
+	}
+	if a, ok := m.Answer[0].(*dns.A); ok {
+		return a.A.String()
+	}
+	return ""
+}
+
+func TestExchangeDNS(t *testing.T) {
+	handler := &testHandler{answer: "192.0.2.1"}
+	addr := startDNS(t, handler, true)
+	for _, transport := range []string{"udp", "tcp"} {
+		resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), testRecord(t, addr, models.Upstream{}), transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := answerOf(resp); got != "192.0.2.1" {
+			t.Errorf("%s: answer = %q, want 192.0.2.1", transport, got)
+		}
+		if handler.count(transport) != 1 {
+			t.Errorf("%s: handler received %d queries, want 1", transport, handler.count(transport))
+		}
+	}
+}
+
+func TestExchangeDNSRetries(t *testing.T) {
+	handler := &testHandler{answer: "192.0.2.1", drop: 2}
+	addr := startDNS(t, handler, false)
+	start := time.Now()
+	resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), testRecord(t, addr, models.Upstream{Timeout: 100, Retries: 2}), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := answerOf(resp); got != "192.0.2.1" {
+		t.Errorf("answer = %q, want 192.0.2.1", got)
+	}
+	if n := handler.count("udp"); n != 3 {
+		t.Errorf("handler received %d queries, want 3", n)
+	}
+	// Two timeouts and the backoff before each retry.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond+3*upstreamBackoff {
+		t.Errorf("retries took %v, want at least %v", elapsed, 200*time.Millisecond+3*upstreamBackoff)
+	}
+}
+
+func TestExchangeDNSFallbacks(t *testing.T) {
+	down := closedAddr(t)
+	silent := &testHandler{drop: 1 << 30}
+	silentAddr := startDNS(t, silent, false)
+	fallback := &testHandler{answer: "192.0.2.2"}
+	fallbackAddr := startDNS(t, fallback, false)
+
+	record := testRecord(t, down, models.Upstream{Timeout: 100, Fallbacks: []string{silentAddr, fallbackAddr}})
+	resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), record, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := answerOf(resp); got != "192.0.2.2" {
+		t.Errorf("answer = %q, want the fallback's 192.0.2.2", got)
+	}
+	if silent.count("udp") != 1 || fallback.count("udp") != 1 {
+		t.Errorf("fallbacks received %d and %d queries, want 1 and 1", silent.count("udp"), fallback.count("udp"))
+	}
+}
+
+func TestExchangeDNSFailures(t *testing.T) {
+	down := closedAddr(t)
+	silent := &testHandler{drop: 1 << 30}
+	silentAddr := startDNS(t, silent, false)
+
+	record := testRecord(t, down, models.Upstream{Timeout: 50, Retries: 1, Fallbacks: []string{silentAddr}})
+	resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), record, "udp")
+	if err == nil {
+		t.Fatalf("exchange succeeded with %v", resp)
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "no dns handler of c2.example.org responded: ") {
+		t.Errorf("unexpected error %q", msg)
+	}
+	// Every address is tried on both attempts and each failure is reported.
+	if n := strings.Count(msg, down+": read udp"); n != 2 {
+		t.Errorf("error reports %d failures of %s, want 2: %s", n, down, msg)
+	}
+	if n := strings.Count(msg, silentAddr+": read udp"); n != 2 {
+		t.Errorf("error reports %d failures of %s, want 2: %s", n, silentAddr, msg)
+	}
+	if n := silent.count("udp"); n != 2 {
+		t.Errorf("fallback received %d queries, want 2", n)
+	}
+}
+
+func TestExchangeDNSTruncated(t *testing.T) {
+	handler := &testHandler{answer: "192.0.2.3", truncate: true}
+	addr := startDNS(t, handler, true)
+	resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), testRecord(t, addr, models.Upstream{}), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Truncated || answerOf(resp) != "192.0.2.3" {
+		t.Errorf("response = %v, want the full answer over TCP", resp)
+	}
+	if handler.count("udp") != 1 || handler.count("tcp") != 1 {
+		t.Errorf("handler received %d UDP and %d TCP queries, want 1 and 1", handler.count("udp"), handler.count("tcp"))
+	}
+}
+
+func TestExchangeDNSTruncatedWithoutTCP(t *testing.T) {
+	handler := &testHandler{answer: "192.0.2.3", truncate: true}
+	addr := startDNS(t, handler, false)
+	resp, err := exchangeDNS(question("x.c2.example.org", dns.TypeA), testRecord(t, addr, models.Upstream{Timeout: 100}), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Truncated || len(resp.Answer) != 0 {
+		t.Errorf("response = %v, want the truncated UDP response", resp)
+	}
+	if handler.count("udp") != 1 {
+		t.Errorf("handler received %d UDP queries, want 1", handler.count("udp"))
+	}
+}
@@ -18,9 +18,100 @@ func isSameAsListener(listener string, req *models.RecordRequest) bool {
 	return listener == req.HandlerHost+":"+strconv.Itoa(req.HandlerPort)
 }
 
+// checkFQDN returns an error message if fqdn can not be used by the record with the provided id
+// because another record already has it, or because the name is answered by a zone and the
+// record would never receive its DNS queries.
+func checkFQDN(server *app.App, id, fqdn string, answered bool) (int, string) {
+	existing, err := models.FindRecordByFQDN(server.DB, fqdn)
+	if err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError, "there was an error getting records from the database"
+	}
+	if existing.ID != "" && existing.ID != id {
+		return http.StatusBadRequest, "fqdn must be unique across the application"
+	}
+	if !answered {
+		return http.StatusOK, ""
+	}
+	zone, err := models.FindZoneForName(server.DB, fqdn)
+	if err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError, "there was an error getting zones from the database"
+	}
+	if zone.ID != "" && answersZone(zone, fqdn) {
+		return http.StatusBadRequest, "fqdn is answered by the zone " + zone.Name + ", remove answers and match_apex"
+	}
+	return http.StatusOK, ""
+}
+
+// loadVisibleRecord looks up the record provided by the mux parameter id, writing a not found
+// response if it does not exist or is not visible to the current user.
+func loadVisibleRecord(server *app.App, w http.ResponseWriter, req *http.Request) (*models.Record, bool) {
+	record := &models.Record{ID: mux.Vars(req)["id"]}
+	if ok, err := server.DB.Exists(record); err != nil || !ok {
+		server.Render.JSON(w, http.StatusNotFound, nil)
+		return record, false
+	}
+	if err := server.DB.Get(record); err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record from the database"})
+		log.Println(err)
+		return record, false
+	}
+	return record, checkVisible(server, w, req, record)
+}
+
+// checkVisible writes a not found response and returns false if record is not visible to the
+// current user.
+func checkVisible(server *app.App, w http.ResponseWriter, req *http.Request, record *models.Record) bool {
+	if ok, err := models.RecordVisible(server.DB, currentUser(req), record); err != nil || !ok {
+		if err != nil {
+			log.Println(err)
+		}
+		server.Render.JSON(w, http.StatusNotFound, nil)
+		return false
+	}
+	return true
+}
+
+// forbidRecord writes the response used when a user may not modify a record.
+func forbidRecord(server *app.App, w http.ResponseWriter) {
+	server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "only the owner of this record, a user it is shared with, or an admin may modify it"})
+}
+
+// sameMembers returns true if a and b contain the same strings, ignoring order.
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateUsers returns an error message if any of the user ids provided in field do not exist.
+func validateUsers(server *app.App, field string, ids []string) string {
+	for _, id := range ids {
+		if ok, err := server.DB.Exists(&models.User{ID: id}); err != nil || !ok {
+			return field + " contains a user that does not exist"
+		}
+	}
+	return ""
+}
+
 // CreateRecord handles a request to create a new record.
 func CreateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
 		user := &models.User{}
 		user = context.Get(req, "user").(*models.User)
 		recordReq := &models.RecordRequest{}
@@ -35,16 +126,37 @@ func CreateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "Handler Host and Handler Port must not be the same as SSL Listener"})
 			return
 		}
-		existing, err := models.FindRecordByFQDN(server.DB, recordReq.FQDN)
-		if err != nil {
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the record to the database"})
-			log.Println(err)
+		recordReq.FQDN = models.CanonicalName(recordReq.FQDN)
+		if status, msg := checkFQDN(server, "", recordReq.FQDN, len(recordReq.Answers) > 0 || recordReq.MatchApex); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
+			return
+		}
+		if msg := validateUsers(server, "shared_with", recordReq.SharedWith); msg != "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 			return
 		}
-		if existing.ID != "" {
-			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "fqdn must be unique across the application"})
+		if status, msg := checkProject(server, user, recordReq.ProjectID); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
 			return
 		}
+		if recordReq.SharedWith == nil {
+			recordReq.SharedWith = []string{}
+		}
+		if recordReq.Windows == nil {
+			recordReq.Windows = []models.Window{}
+		}
+		if recordReq.Answers == nil {
+			recordReq.Answers = []models.Answer{}
+		}
+		if recordReq.Failure.Answers == nil {
+			recordReq.Failure.Answers = []models.Answer{}
+		}
+		if recordReq.Upstream.Fallbacks == nil {
+			recordReq.Upstream.Fallbacks = []string{}
+		}
+		if recordReq.QueryTypes == nil {
+			recordReq.QueryTypes = []string{}
+		}
 		now := time.Now().Unix()
 		record := &models.Record{
 			CreatedAt: now,
@@ -52,6 +164,7 @@ func CreateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 		}
 		record.Owner.Email = user.Email
 		record.Owner.ID = user.ID
+		record.Version = 1
 		if err := copier.Copy(record, recordReq); err != nil {
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the record to the database"})
 			return
@@ -61,51 +174,62 @@ func CreateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the record to the database"})
 			return
 		}
+		if err := models.AddRecordVersion(server.DB, record, nil, user, "create"); err != nil {
+			log.Println(err)
+		}
+		audit(server, req, user, "record.create", "record", record.ID, nil, record)
 
-		server.Render.JSON(w, http.StatusCreated, record)
+		server.Render.JSON(w, http.StatusCreated, record.View(time.Now()))
 	}
 }
 
-// IndexRecord handles a request to return a list of all records.
+// IndexRecord handles a request to return a list of all records visible to the user.
 func IndexRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-
-		records := []models.Record{}
-		if err := server.DB.All(&records); err != nil {
+		records, err := models.FindRecordsForUser(server.DB, currentUser(req))
+		if err != nil {
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting records from the database"})
 			return
 		}
-		server.Render.JSON(w, http.StatusOK, records)
+		now := time.Now()
+		views := []*models.RecordView{}
+		for i := range records {
+			views = append(views, records[i].View(now))
+		}
+		server.Render.JSON(w, http.StatusOK, views)
 	}
 }
 
 // ShowRecord handles a request to return a single record provided by the mux parameter id.
 func ShowRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		vars := mux.Vars(req)
-		id := vars["id"]
-		record := &models.Record{ID: id}
-		if ok, err := server.DB.Exists(record); err != nil || !ok {
-			server.Render.JSON(w, http.StatusNotFound, nil)
-			return
-		}
-		if err := server.DB.Get(record); err != nil {
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record from the database"})
-			log.Println(err)
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
 			return
 		}
-		server.Render.JSON(w, http.StatusOK, record)
+		server.Render.JSON(w, http.StatusOK, record.View(time.Now()))
 	}
 }
 
-// DeleteRecord handles a request to delete a single record provided the mux parameter id.
+// DeleteRecord handles a request to delete a single record provided the mux parameter id. Its
+// history is kept so that it can be restored with a rollback.
 func DeleteRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		vars := mux.Vars(req)
-		id := vars["id"]
-		record := &models.Record{ID: id}
-		if ok, err := server.DB.Exists(record); err != nil || !ok {
-			server.Render.JSON(w, http.StatusNotFound, nil)
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
+			return
+		}
+		id := record.ID
+		user := currentUser(req)
+		if !record.CanModify(user) {
+			forbidRecord(server, w)
+			return
+		}
+		if status, msg := checkProject(server, user, record.ProjectID); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
 			return
 		}
 		if err := server.DB.Delete(record); err != nil {
@@ -113,6 +237,16 @@ func DeleteRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 			log.Println(err)
 			return
 		}
+		if err := server.Captures.Remove(id); err != nil {
+			log.Println(err)
+		}
+		deleted := *record
+		deleted.Version++
+		if err := models.AddRecordVersion(server.DB, &deleted, nil, user, "delete"); err != nil {
+			log.Println(err)
+		}
+		server.Stats.Remove(id)
+		audit(server, req, user, "record.delete", "record", id, record, nil)
 		server.Render.JSON(w, http.StatusNoContent, nil)
 	}
 }
@@ -120,11 +254,11 @@ func DeleteRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 // UpdateRecord handles a request to update a single record provided the mux parameter id.
 func UpdateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		vars := mux.Vars(req)
-		id := vars["id"]
-		record := &models.Record{ID: id}
-		if ok, err := server.DB.Exists(record); err != nil || !ok {
-			server.Render.JSON(w, http.StatusNotFound, nil)
+		if !requireRole(server, w, req, models.RoleOperator) {
+			return
+		}
+		record, ok := loadVisibleRecord(server, w, req)
+		if !ok {
 			return
 		}
 
@@ -133,42 +267,108 @@ func UpdateRecord(server *app.App) func(w http.ResponseWriter, req *http.Request
 			return
 		}
 
-		if err := server.DB.Get(record); err != nil {
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting record from the database"})
-			log.Println(err)
+		if !record.CanModify(currentUser(req)) {
+			forbidRecord(server, w)
 			return
 		}
+		updateRecord(server, w, req, record, updateReq, "update")
+	}
+}
 
-		if updateReq.FQDN != record.FQDN {
-			existing, err := models.FindRecordByFQDN(server.DB, updateReq.FQDN)
-			if err != nil {
-				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the record to the database"})
-				log.Println(err)
-				return
-			}
-			if existing.ID != "" {
-				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "fqdn must be unique across the application"})
-				return
+// updateRecord applies updateReq to record on behalf of the current user, who must be able to
+// modify it, and writes the response. A new version of the record is added to its history with
+// action, which is either update or rollback. A rollback to a state that is no longer valid is
+// a conflict rather than a bad request.
+func updateRecord(server *app.App, w http.ResponseWriter, req *http.Request, record *models.Record, updateReq *models.UpdateRecordRequest, action string) {
+	user := currentUser(req)
+	invalid := http.StatusBadRequest
+	if action == "rollback" {
+		invalid = http.StatusConflict
+	}
+	if updateReq.SharedWith == nil {
+		updateReq.SharedWith = record.SharedWith
+	}
+	if updateReq.Windows == nil {
+		updateReq.Windows = []models.Window{}
+	}
+	if updateReq.Answers == nil {
+		updateReq.Answers = []models.Answer{}
+	}
+	if updateReq.Failure.Answers == nil {
+		updateReq.Failure.Answers = []models.Answer{}
+	}
+	if updateReq.Upstream.Fallbacks == nil {
+		updateReq.Upstream.Fallbacks = []string{}
+	}
+	if updateReq.QueryTypes == nil {
+		updateReq.QueryTypes = []string{}
+	}
+	if (updateReq.Owner.ID != record.Owner.ID || !sameMembers(updateReq.SharedWith, record.SharedWith)) && !record.IsOwner(user) {
+		server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "only the owner of this record or an admin may change its owner or sharing"})
+		return
+	}
+	if msg := validateUsers(server, "shared_with", updateReq.SharedWith); msg != "" {
+		server.Render.JSON(w, invalid, map[string]string{"error": msg})
+		return
+	}
+
+	if updateReq.ProjectID != record.ProjectID || record.ProjectID != "" {
+		if status, msg := checkProject(server, user, updateReq.ProjectID); msg != "" {
+			if status == http.StatusBadRequest {
+				status = invalid
 			}
+			server.Render.JSON(w, status, map[string]string{"error": msg})
+			return
 		}
+	}
 
-		if updateReq.Owner.ID != record.ID {
-			if ok, err := server.DB.Exists(&models.User{ID: updateReq.Owner.ID}); err != nil || !ok {
-				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "owner does not exist"})
-				return
-			}
+	updateReq.FQDN = models.CanonicalName(updateReq.FQDN)
+	if status, msg := checkFQDN(server, record.ID, updateReq.FQDN, len(updateReq.Answers) > 0 || updateReq.MatchApex); msg != "" {
+		if status == http.StatusBadRequest {
+			status = invalid
 		}
+		server.Render.JSON(w, status, map[string]string{"error": msg})
+		return
+	}
 
-		if err := copier.Copy(record, updateReq); err != nil {
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the record"})
+	if updateReq.Owner.ID != record.Owner.ID || action == "rollback" {
+		owner := &models.User{ID: updateReq.Owner.ID}
+		if ok, err := server.DB.Exists(owner); err != nil || !ok {
+			server.Render.JSON(w, invalid, map[string]string{"error": "owner does not exist"})
 			return
 		}
-		record.UpdatedAt = time.Now().Unix()
-		if err := server.DB.Save(record); err != nil {
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the record"})
+		if err := server.DB.Get(owner); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting owner from the database"})
 			log.Println(err)
 			return
 		}
-		server.Render.JSON(w, http.StatusOK, record)
+		updateReq.Owner.Email = owner.Email
+	} else {
+		updateReq.Owner.Email = record.Owner.Email
+	}
+
+	before := *record
+	if err := copier.Copy(record, updateReq); err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the record"})
+		return
+	}
+	record.UpdatedAt = time.Now().Unix()
+	record.Version = before.Version + 1
+	if err := server.DB.Save(record); err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the record"})
+		log.Println(err)
+		return
+	}
+	if err := models.AddRecordVersion(server.DB, record, &before, user, action); err != nil {
+		log.Println(err)
+	}
+	auditAction := "record." + action
+	if action == "update" && record.Blacklist != before.Blacklist {
+		auditAction = "record.unblacklist"
+		if record.Blacklist {
+			auditAction = "record.blacklist"
+		}
 	}
+	audit(server, req, user, auditAction, "record", record.ID, &before, record)
+	server.Render.JSON(w, http.StatusOK, record.View(time.Now()))
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// rewriteName replaces the domain from at the end of name with to. Names outside of from are
+// returned unchanged. The labels before the domain keep their case.
+func rewriteName(name, from, to string) string {
+	from = strings.ToLower(dns.Fqdn(from))
+	lower := strings.ToLower(dns.Fqdn(name))
+	switch {
+	case lower == from:
+		return dns.Fqdn(to)
+	case strings.HasSuffix(lower, "."+from):
+		return dns.Fqdn(name)[:len(lower)-len(from)] + dns.Fqdn(to)
+	}
+	return name
+}
+
+// rewriteMsg replaces the domain from with to in the owner names of every record in m and in
+// the names contained in their data.
+func rewriteMsg(m *dns.Msg, from, to string) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			hdr.Name = rewriteName(hdr.Name, from, to)
+			switch v := rr.(type) {
+			case *dns.CNAME:
+				v.Target = rewriteName(v.Target, from, to)
+			case *dns.DNAME:
+				v.Target = rewriteName(v.Target, from, to)
+			case *dns.MX:
+				v.Mx = rewriteName(v.Mx, from, to)
+			case *dns.NS:
+				v.Ns = rewriteName(v.Ns, from, to)
+			case *dns.PTR:
+				v.Ptr = rewriteName(v.Ptr, from, to)
+			case *dns.SRV:
+				v.Target = rewriteName(v.Target, from, to)
+			case *dns.SOA:
+				v.Ns = rewriteName(v.Ns, from, to)
+				v.Mbox = rewriteName(v.Mbox, from, to)
+			}
+		}
+	}
+}
+
+// restoreMsg undoes the rewrite of record in resp, the response of its handler to req. The
+// domain is restored as the client sent it, resolvers may randomize its case.
+func restoreMsg(resp, req *dns.Msg, record *models.Record) {
+	qname := dns.Fqdn(req.Question[0].Name)
+	rewriteMsg(resp, record.RewriteTo, qname[len(qname)-len(dns.Fqdn(record.FQDN)):])
+	resp.Question = req.Question
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+func TestRewriteName(t *testing.T) {
+	tests := []struct {
+		name, from, to, want string
+	}{
+		{"c2.example.com.", "c2.example.com", "internal.lan", "internal.lan."},
+		{"C2.Example.COM.", "c2.example.com.", "internal.lan", "internal.lan."},
+		{"x.c2.example.com.", "c2.example.com", "internal.lan", "x.internal.lan."},
+		{"AbC.xYz.c2.example.com.", "C2.EXAMPLE.COM", "internal.lan", "AbC.xYz.internal.lan."},
+		{"x.c2.example.com", "c2.example.com", "internal.lan.", "x.internal.lan."},
+		{"xc2.example.com.", "c2.example.com", "internal.lan", "xc2.example.com."},
+		{"example.com.", "c2.example.com", "internal.lan", "example.com."},
+		{"other.org.", "c2.example.com", "internal.lan", "other.org."},
+		{"x.internal.lan.", "internal.lan", "C2.Example.com.", "x.C2.Example.com."},
+	}
+	for _, tt := range tests {
+		if got := rewriteName(tt.name, tt.from, tt.to); got != tt.want {
+			t.Errorf("rewriteName(%s, %s, %s) = %s, want %s", tt.name, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// mustRR parses rr or fails the test.
+func mustRR(t *testing.T, rr string) dns.RR {
+	r, err := dns.NewRR(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRewriteMsg(t *testing.T) {
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{
+		mustRR(t, "a.internal.lan. 60 IN CNAME b.Internal.lan."),
+		mustRR(t, "b.internal.lan. 60 IN A 192.0.2.1"),
+		mustRR(t, "d.internal.lan. 60 IN DNAME e.internal.lan."),
+		mustRR(t, "internal.lan. 60 IN MX 10 mail.internal.lan."),
+		mustRR(t, "_x._tcp.internal.lan. 60 IN SRV 0 0 443 srv.internal.lan."),
+		mustRR(t, "1.2.0.192.in-addr.arpa. 60 IN PTR host.internal.lan."),
+		mustRR(t, "x.internal.lan. 60 IN CNAME elsewhere.example.net."),
+	}
+	m.Ns = []dns.RR{
+		mustRR(t, "internal.lan. 60 IN NS ns.internal.lan."),
+		mustRR(t, "internal.lan. 60 IN SOA ns.internal.lan. hostmaster.internal.lan. 1 2 3 4 5"),
+	}
+	m.Extra = []dns.RR{mustRR(t, "ns.internal.lan. 60 IN A 192.0.2.53")}
+	m.SetEdns0(1232, false)
+
+	rewriteMsg(m, "internal.lan", "C2.example.COM.")
+	want := []string{
+		"a.C2.example.COM.\t60\tIN\tCNAME\tb.C2.example.COM.",
+		"b.C2.example.COM.\t60\tIN\tA\t192.0.2.1",
+		"d.C2.example.COM.\t60\tIN\tDNAME\te.C2.example.COM.",
+		"C2.example.COM.\t60\tIN\tMX\t10 mail.C2.example.COM.",
+		"_x._tcp.C2.example.COM.\t60\tIN\tSRV\t0 0 443 srv.C2.example.COM.",
+		"1.2.0.192.in-addr.arpa.\t60\tIN\tPTR\thost.C2.example.COM.",
+		"x.C2.example.COM.\t60\tIN\tCNAME\telsewhere.example.net.",
+	}
+	if got := rrStrings(m.Answer); !reflect.DeepEqual(got, want) {
+		t.Errorf("answer = %q, want %q", got, want)
+	}
+	want = []string{
+		"C2.example.COM.\t60\tIN\tNS\tns.C2.example.COM.",
+		"C2.example.COM.\t60\tIN\tSOA\tns.C2.example.COM. hostmaster.C2.example.COM. 1 2 3 4 5",
+	}
+	if got := rrStrings(m.Ns); !reflect.DeepEqual(got, want) {
+		t.Errorf("authority = %q, want %q", got, want)
+	}
+	if got := m.Extra[0].Header().Name; got != "ns.C2.example.COM." {
+		t.Errorf("additional = %s, want ns.C2.example.COM.", got)
+	}
+	if opt := m.IsEdns0(); opt == nil || opt.Hdr.Name != "." {
+		t.Errorf("OPT record was rewritten: %v", opt)
+	}
+}
+
+func TestRestoreMsg(t *testing.T) {
+	record := &models.Record{FQDN: "c2.example.com", RewriteTo: "internal.lan"}
+	tests := []struct {
+		qname, answer, want string
+	}{
+		{"x.c2.example.com.", "x.internal.lan.", "x.c2.example.com."},
+		// Resolvers randomizing the case of names expect it back as they sent it.
+		{"X.c2.ExAmPlE.cOm.", "X.internal.lan.", "X.c2.ExAmPlE.cOm."},
+		{"X.C2.EXAMPLE.COM.", "x.Internal.LAN.", "x.C2.EXAMPLE.COM."},
+		{"c2.EXAMPLE.com.", "internal.lan.", "c2.EXAMPLE.com."},
+	}
+	for _, tt := range tests {
+		req := question(tt.qname, dns.TypeA)
+		query := req.Copy()
+		query.Question[0].Name = rewriteName(query.Question[0].Name, record.FQDN, record.RewriteTo)
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		resp.Answer = []dns.RR{mustRR(t, tt.answer+" 60 IN A 192.0.2.1")}
+
+		restoreMsg(resp, req, record)
+		if got := resp.Answer[0].Header().Name; got != tt.want {
+			t.Errorf("%s: answer name = %s, want %s", tt.qname, got, tt.want)
+		}
+		if got := resp.Question[0].Name; got != tt.qname {
+			t.Errorf("%s: question = %s, want the client's", tt.qname, got)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// ShowSettings handles a request to return the application settings.
+func ShowSettings(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		settings, err := models.GetSettings(server.DB)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting settings from the database"})
+			log.Println(err)
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, settings)
+	}
+}
+
+// UpdateSettings handles a request to update the application settings.
+func UpdateSettings(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		settingsReq := &models.SettingsRequest{}
+		if err := binding.Bind(req, settingsReq); err.Handle(w) {
+			return
+		}
+		before, settings, err := models.UpdateSettings(server.DB, settingsReq)
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving settings to the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "settings.update", "settings", settings.ID, before, settings)
+		server.Render.JSON(w, http.StatusOK, settings)
+	}
+}
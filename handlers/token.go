@@ -1,45 +1,256 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"code.google.com/p/go-uuid/uuid"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
 	"github.com/mholt/binding"
 	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/auth"
 	"github.com/tomsteele/shellsquid/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/tomsteele/shellsquid/throttle"
 )
 
-// UserToken returns an HTTP handler to generate a token for a user.
+// signToken creates a signed JWT of the given type for user that expires after ttl seconds.
+func signToken(server *app.App, user *models.User, typ string, ttl int64) (string, int64, error) {
+	now := time.Now().Unix()
+	exp := now + ttl
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Claims["id"] = user.ID
+	token.Claims["typ"] = typ
+	token.Claims["ver"] = user.TokenVersion
+	token.Claims["jti"] = uuid.New()
+	token.Claims["iat"] = now
+	token.Claims["exp"] = exp
+	tokenString, err := token.SignedString(server.JWTSecret)
+	return tokenString, exp, err
+}
+
+// newTokens creates a new access and refresh token pair for user.
+func newTokens(server *app.App, user *models.User) (string, string, int64, error) {
+	accessToken, exp, err := signToken(server, user, "access", server.Config.AccessTokenTTL)
+	if err != nil {
+		return "", "", 0, err
+	}
+	refreshToken, _, err := signToken(server, user, "refresh", server.Config.RefreshTokenTTL)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return accessToken, refreshToken, exp, nil
+}
+
+// issueTokens writes a new access and refresh token pair for user.
+func issueTokens(server *app.App, w http.ResponseWriter, user *models.User) {
+	accessToken, refreshToken, exp, err := newTokens(server, user)
+	if err != nil {
+		log.Println(err)
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error signing token"})
+		return
+	}
+	server.Render.JSON(w, http.StatusCreated, map[string]interface{}{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_at":    exp,
+	})
+}
+
+// revokeToken revokes the token id found in the claims until the token's expiration.
+func revokeToken(server *app.App, claims map[string]interface{}) error {
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" {
+		return errors.New("token does not have an id")
+	}
+	return models.RevokeToken(server.DB, jti, int64(exp))
+}
+
+// parseRefreshToken validates a refresh token and returns its claims along with the user it was issued to.
+func parseRefreshToken(server *app.App, tokenString string) (map[string]interface{}, *models.User, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		if typ, _ := token.Claims["typ"].(string); typ != "refresh" {
+			return nil, errors.New("token is not a refresh token")
+		}
+		return server.JWTSecret, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := models.ValidateTokenClaims(server.DB, token.Claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token.Claims, user, nil
+}
+
+// tooManyAttempts writes a response telling the client to wait before trying to log in again.
+func tooManyAttempts(server *app.App, w http.ResponseWriter, wait time.Duration) {
+	seconds := int64(wait/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	server.Render.JSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many failed login attempts, try again later"})
+}
+
+// loginUser returns the user with email along with their failed logins. Unknown emails return
+// a user without an id holding the failed logins kept in memory for that email, so that they
+// are throttled and locked exactly like existing accounts.
+func loginUser(server *app.App, email string) (*models.User, error) {
+	user, err := models.FindUserByEmail(server.DB, email)
+	if err != nil || user.ID != "" {
+		return user, err
+	}
+	account := server.UnknownLogins.Get(email)
+	user.Email = email
+	user.FailedLogins = account.Failures
+	user.LastFailedLogin = account.LastFailure
+	user.LockedUntil = account.LockedUntil
+	return user, nil
+}
+
+// loginFailed records a failed login for the client and user, which may be an unknown user
+// returned by loginUser.
+func loginFailed(server *app.App, req *http.Request, user *models.User) {
+	server.Logins.Failure(remoteIP(req))
+	audit(server, req, user, "login.failed", "user", user.ID, nil, nil)
+	user.RecordLoginFailure(time.Now().Unix(), server.Config.Login.LockoutThreshold, server.Config.Login.LockoutDuration)
+	if user.ID == "" {
+		server.UnknownLogins.Set(user.Email, throttle.Account{
+			Failures:    user.FailedLogins,
+			LastFailure: user.LastFailedLogin,
+			LockedUntil: user.LockedUntil,
+		})
+		return
+	}
+	if err := server.DB.Save(user); err != nil {
+		log.Println(err)
+	}
+}
+
+// UserToken returns an HTTP handler to generate a token for a user. Failed logins are throttled
+// per client IP and per account with an exponential backoff, and accounts are locked after the
+// configured number of consecutive failures. Unknown accounts get the same responses as
+// existing ones.
 func UserToken(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		userTokenReq := &models.UserTokenRequest{}
 		if err := binding.Bind(req, userTokenReq); err.Handle(w) {
 			return
 		}
-		user, err := models.FindUserByEmail(server.DB, userTokenReq.Email)
+		existing, err := loginUser(server, userTokenReq.Email)
 		if err != nil {
 			log.Println(err)
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error getting user from database"})
 			return
 		}
-		if user.ID == "" {
-			server.Render.JSON(w, http.StatusNotFound, map[string]string{"error": "invalid username or password"})
+		ip := remoteIP(req)
+		if wait := server.Logins.Wait(ip); wait > 0 {
+			audit(server, req, existing, "login.throttled", "user", existing.ID, nil, nil)
+			tooManyAttempts(server, w, wait)
+			return
+		}
+		now := time.Now()
+		if existing.Locked(now.Unix()) {
+			audit(server, req, existing, "login.locked", "user", existing.ID, nil, nil)
+			w.Header().Set("Retry-After", strconv.FormatInt(existing.LockedUntil-now.Unix(), 10))
+			server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "account is locked, try again later or contact an admin"})
+			return
+		}
+		maxBackoff := time.Duration(server.Config.Login.MaxBackoff) * time.Second
+		next := time.Unix(existing.LastFailedLogin, 0).Add(throttle.Delay(existing.FailedLogins, time.Second, maxBackoff))
+		if now.Before(next) {
+			audit(server, req, existing, "login.throttled", "user", existing.ID, nil, nil)
+			tooManyAttempts(server, w, next.Sub(now))
 			return
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(userTokenReq.Password)); err != nil {
+		user, err := server.Authenticator.Authenticate(server.DB, userTokenReq.Email, userTokenReq.Password)
+		if err == auth.ErrInvalidCredentials {
+			loginFailed(server, req, existing)
 			server.Render.JSON(w, http.StatusNotFound, map[string]string{"error": "invalid username or password"})
 			return
 		}
-		token := jwt.New(jwt.GetSigningMethod("HS256"))
-		token.Claims["id"] = user.ID
-		tokenString, err := token.SignedString(server.JWTSecret)
 		if err != nil {
+			// The attempt is counted so that accounts can not be probed while the backend is down.
+			log.Println(err)
+			loginFailed(server, req, existing)
+			server.Render.JSON(w, http.StatusServiceUnavailable, map[string]string{"error": "error contacting authentication backend"})
+			return
+		}
+		if user.TOTPEnabled {
+			if userTokenReq.Code == "" {
+				server.Render.JSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "two-factor code required", "totp_required": true})
+				return
+			}
+			if !user.VerifyTOTP(userTokenReq.Code) && !user.UseRecoveryCode(userTokenReq.Code) {
+				loginFailed(server, req, user)
+				server.Render.JSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid two-factor code"})
+				return
+			}
+		}
+		server.Logins.Reset(ip)
+		user.ResetLoginFailures()
+		if err := server.DB.Save(user); err != nil {
 			log.Println(err)
-			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error signing token"})
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error updating user"})
 			return
 		}
-		server.Render.JSON(w, http.StatusCreated, map[string]string{"token": tokenString})
+		audit(server, req, user, "login", "user", user.ID, nil, nil)
+		issueTokens(server, w, user)
+	}
+}
+
+// RefreshToken returns an HTTP handler to exchange a refresh token for a new token pair.
+// The refresh token that was used is revoked.
+func RefreshToken(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		refreshReq := &models.RefreshTokenRequest{}
+		if err := binding.Bind(req, refreshReq); err.Handle(w) {
+			return
+		}
+		claims, user, err := parseRefreshToken(server, refreshReq.RefreshToken)
+		if err != nil {
+			server.Render.JSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+			return
+		}
+		if err := revokeToken(server, claims); err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error revoking token"})
+			return
+		}
+		issueTokens(server, w, user)
+	}
+}
+
+// Logout returns an HTTP handler that revokes the access token used for the request. If a
+// refresh token is provided in the body it is revoked as well.
+func Logout(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token, ok := context.Get(req, "token").(*jwt.Token)
+		if !ok {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "logout requires a token, revoke API keys through /api/keys"})
+			return
+		}
+		if err := revokeToken(server, token.Claims); err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "error revoking token"})
+			return
+		}
+		refreshReq := &models.RefreshTokenRequest{}
+		if req.ContentLength > 0 && len(binding.Bind(req, refreshReq)) == 0 {
+			claims, user, err := parseRefreshToken(server, refreshReq.RefreshToken)
+			if err == nil && user.ID == currentUser(req).ID {
+				if err := revokeToken(server, claims); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		audit(server, req, currentUser(req), "logout", "user", currentUser(req).ID, nil, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
 	}
 }
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// newTestUser saves a user to the database of server.
+func newTestUser(t *testing.T, server *app.App) *models.User {
+	user := &models.User{ID: "user-1", Email: "alice@example.org", Role: models.RoleAdmin}
+	if err := server.DB.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// parseClaims returns the claims of a token signed by server without validating them.
+func parseClaims(t *testing.T, server *app.App, tokenString string) map[string]interface{} {
+	token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+		return server.JWTSecret, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Claims
+}
+
+func TestSignToken(t *testing.T) {
+	server := newTestApp(t)
+	user := newTestUser(t, server)
+	before := time.Now().Unix()
+	tokenString, exp, err := signToken(server, user, "access", 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp < before+900 || exp > time.Now().Unix()+900 {
+		t.Errorf("exp = %d, want 900 seconds from now", exp)
+	}
+	claims := parseClaims(t, server, tokenString)
+	if claims["id"] != user.ID || claims["typ"] != "access" || claims["exp"] != float64(exp) {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		t.Error("token has no id")
+	}
+}
+
+func TestParseRefreshToken(t *testing.T) {
+	server := newTestApp(t)
+	user := newTestUser(t, server)
+	sign := func(typ string, ttl int64) string {
+		tokenString, _, err := signToken(server, user, typ, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	valid := sign("refresh", 3600)
+	claims, got, err := parseRefreshToken(server, valid)
+	if err != nil {
+		t.Fatalf("valid refresh token rejected: %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("refresh token issued to %s, want %s", got.ID, user.ID)
+	}
+
+	revoked := sign("refresh", 3600)
+	revokedClaims := parseClaims(t, server, revoked)
+	if err := revokeToken(server, revokedClaims); err != nil {
+		t.Fatal(err)
+	}
+	other := &models.User{ID: "user-2", Email: "bob@example.org", Role: models.RoleAdmin}
+	unknown, _, err := signToken(server, other, "refresh", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.New(jwt.SigningMethodHS256)
+	for k, v := range claims {
+		forged.Claims[k] = v
+	}
+	forgedString, err := forged.SignedString([]byte("other secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"access token", sign("access", 3600)},
+		{"expired", sign("refresh", -10)},
+		{"revoked", revoked},
+		{"unknown user", unknown},
+		{"wrong key", forgedString},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseRefreshToken(server, tt.token); err == nil {
+			t.Errorf("%s: refresh token accepted", tt.name)
+		}
+	}
+
+	// Bumping the token version invalidates every outstanding token.
+	user.TokenVersion++
+	if err := server.DB.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseRefreshToken(server, valid); err == nil {
+		t.Error("refresh token from an older token version accepted")
+	}
+}
+
+func TestRefreshTokenRevokesUsedToken(t *testing.T) {
+	server := newTestApp(t)
+	user := newTestUser(t, server)
+	refreshToken, _, err := signToken(server, user, "refresh", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/token/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		RefreshToken(server)(w, req)
+		return w
+	}
+
+	w := refresh()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("refresh = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresAt    int64  `json:"expires_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Token == "" || body.RefreshToken == "" || body.RefreshToken == refreshToken {
+		t.Errorf("unexpected token pair %+v", body)
+	}
+	if claims := parseClaims(t, server, body.Token); claims["typ"] != "access" || claims["exp"] != float64(body.ExpiresAt) {
+		t.Errorf("unexpected access token claims %v", claims)
+	}
+	if _, _, err := parseRefreshToken(server, body.RefreshToken); err != nil {
+		t.Errorf("new refresh token rejected: %v", err)
+	}
+
+	if w := refresh(); w.Code != http.StatusUnauthorized {
+		t.Errorf("reusing a refresh token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
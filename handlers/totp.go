@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+	"github.com/tomsteele/shellsquid/totp"
+)
+
+// EnrollTOTP handles a request to start TOTP enrollment for the current user. A new secret is
+// generated and returned along with an otpauth URI, enrollment is completed by VerifyTOTP.
+func EnrollTOTP(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		user := currentUser(req)
+		if user.TOTPEnabled {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "two-factor authentication is already enabled, disable it before enrolling again"})
+			return
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error generating a secret"})
+			log.Println(err)
+			return
+		}
+		if err := server.DB.Update(user, map[string]interface{}{"TOTPSecret": secret, "TOTPLastCounter": int64(0)}); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, user, "totp.enroll", "user", user.ID, nil, nil)
+		server.Render.JSON(w, http.StatusCreated, map[string]string{
+			"secret": secret,
+			"uri":    totp.URI(secret, "shellsquid", user.Email),
+		})
+	}
+}
+
+// VerifyTOTP handles a request to complete TOTP enrollment for the current user. On success
+// recovery codes are generated and returned, they are not shown again.
+func VerifyTOTP(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		totpReq := &models.TOTPRequest{}
+		if err := binding.Bind(req, totpReq); err.Handle(w) {
+			return
+		}
+		user := currentUser(req)
+		if user.TOTPEnabled || user.TOTPSecret == "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "two-factor enrollment has not been started"})
+			return
+		}
+		if !user.VerifyTOTP(totpReq.Code) {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "invalid code"})
+			return
+		}
+		codes, err := user.GenerateRecoveryCodes()
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error generating recovery codes"})
+			log.Println(err)
+			return
+		}
+		user.TOTPEnabled = true
+		user.UpdatedAt = time.Now().Unix()
+		if err := server.DB.Save(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, user, "totp.enable", "user", user.ID, nil, nil)
+		server.Render.JSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+	}
+}
+
+// DisableTOTP handles a request to remove TOTP from the current user. A current code or an
+// unused recovery code is required.
+func DisableTOTP(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectAPIKey(server, w, req) {
+			return
+		}
+		totpReq := &models.TOTPRequest{}
+		if err := binding.Bind(req, totpReq); err.Handle(w) {
+			return
+		}
+		user := currentUser(req)
+		if !user.TOTPEnabled {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "two-factor authentication is not enabled"})
+			return
+		}
+		if !user.VerifyTOTP(totpReq.Code) && !user.UseRecoveryCode(totpReq.Code) {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "invalid code"})
+			return
+		}
+		user.DisableTOTP()
+		user.UpdatedAt = time.Now().Unix()
+		if err := server.DB.Save(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, user, "totp.disable", "user", user.ID, nil, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// ResetUserTOTP handles a request by an admin to remove TOTP from a user provided by an id mux
+// parameter, for example when they have lost their device and recovery codes.
+func ResetUserTOTP(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		vars := mux.Vars(req)
+		user := &models.User{ID: vars["id"]}
+		if ok, err := server.DB.Exists(user); err != nil || !ok {
+			server.Render.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		if err := server.DB.Get(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting user from the database"})
+			log.Println(err)
+			return
+		}
+		user.DisableTOTP()
+		user.UpdatedAt = time.Now().Unix()
+		if err := server.DB.Save(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "totp.reset", "user", user.ID, nil, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
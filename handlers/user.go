@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mholt/binding"
@@ -11,9 +12,23 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// isLastAdmin returns true if there is at most one admin user. Errors are treated as true so
+// that the check fails closed.
+func isLastAdmin(server *app.App) bool {
+	count, err := models.CountAdmins(server.DB)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	return count <= 1
+}
+
 // CreateUser is a http handler function to creation a new user.
 func CreateUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
 		userReq := &models.UserRequest{}
 		if err := binding.Bind(req, userReq); err.Handle(w) {
 			return
@@ -34,12 +49,16 @@ func CreateUser(server *app.App) func(w http.ResponseWriter, req *http.Request)
 			log.Println(err)
 			return
 		}
+		if userReq.Role != "" {
+			user.Role = userReq.Role
+		}
 		if err := server.DB.Save(user); err != nil {
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the user to the database"})
 			log.Println(err)
 			return
 		}
-		user.Hash = ""
+		audit(server, req, currentUser(req), "user.create", "user", user.ID, nil, user)
+		sanitizeUser(req, user)
 		server.Render.JSON(w, http.StatusCreated, user)
 	}
 }
@@ -53,8 +72,8 @@ func IndexUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 			log.Println(err)
 			return
 		}
-		for _, u := range users {
-			u.Hash = ""
+		for i := range users {
+			sanitizeUser(req, &users[i])
 		}
 		server.Render.JSON(w, http.StatusOK, users)
 	}
@@ -75,7 +94,7 @@ func ShowUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 			log.Println(err)
 			return
 		}
-		user.Hash = ""
+		sanitizeUser(req, user)
 		server.Render.JSON(w, http.StatusOK, user)
 	}
 }
@@ -83,6 +102,9 @@ func ShowUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 // DeleteUser deletes a single user provided by an id mux parameter.
 func DeleteUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
 		vars := mux.Vars(req)
 		id := vars["id"]
 		user := &models.User{ID: id}
@@ -90,6 +112,15 @@ func DeleteUser(server *app.App) func(w http.ResponseWriter, req *http.Request)
 			server.Render.JSON(w, http.StatusNotFound, nil)
 			return
 		}
+		if err := server.DB.Get(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting user from the database"})
+			log.Println(err)
+			return
+		}
+		if user.IsAdmin() && isLastAdmin(server) {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "at least one admin must remain"})
+			return
+		}
 		if foundRecords, err := models.FindRecordsForOwner(server.DB, id); err != nil || len(foundRecords) > 0 {
 			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "this user has records owned by them, remove or reassign before deleting"})
 			return
@@ -99,6 +130,16 @@ func DeleteUser(server *app.App) func(w http.ResponseWriter, req *http.Request)
 			log.Println(err)
 			return
 		}
+		if err := models.RemoveUserFromShares(server.DB, id); err != nil {
+			log.Println(err)
+		}
+		if err := models.RemoveUserFromProjects(server.DB, id); err != nil {
+			log.Println(err)
+		}
+		if err := models.DeleteAPIKeysForUser(server.DB, id); err != nil {
+			log.Println(err)
+		}
+		audit(server, req, currentUser(req), "user.delete", "user", id, user, nil)
 		server.Render.JSON(w, http.StatusNoContent, nil)
 	}
 }
@@ -113,22 +154,76 @@ func UpdateUser(server *app.App) func(w http.ResponseWriter, req *http.Request)
 			server.Render.JSON(w, http.StatusNotFound, nil)
 			return
 		}
+		current := currentUser(req)
+		if current.ID != id && !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
 		updateReq := &models.UserUpdateRequest{}
 		if err := binding.Bind(req, updateReq); err.Handle(w) {
 			return
 		}
-		hash, err := bcrypt.GenerateFromPassword([]byte(updateReq.Password), 12)
-		if err != nil {
+		if err := server.DB.Get(user); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting user from the database"})
+			log.Println(err)
+			return
+		}
+		before := *user
+		changes := map[string]interface{}{"UpdatedAt": time.Now().Unix()}
+		if updateReq.Role != "" && updateReq.Role != user.Role {
+			if !requireRole(server, w, req, models.RoleAdmin) {
+				return
+			}
+			if user.IsAdmin() && isLastAdmin(server) {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "at least one admin must remain"})
+				return
+			}
+			changes["Role"] = updateReq.Role
+		}
+		if updateReq.Password != "" && user.Source != models.SourceLocal {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "the password of a user from " + user.Source + " must be changed there"})
+			return
+		}
+		if updateReq.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(updateReq.Password), 12)
+			if err != nil {
+				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
+				log.Println(err)
+				return
+			}
+			changes["Hash"] = string(hash)
+			changes["TokenVersion"] = user.TokenVersion + 1
+		}
+		if err := server.DB.Update(user, changes); err != nil {
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
 			log.Println(err)
 			return
 		}
-		if err := server.DB.Update(user, map[string]interface{}{"Hash": string(hash)}); err != nil {
+		audit(server, req, current, "user.update", "user", id, &before, user)
+		sanitizeUser(req, user)
+		server.Render.JSON(w, http.StatusOK, user)
+	}
+}
+
+// UnlockUser clears failed logins and any lockout for a user provided by an id mux parameter.
+func UnlockUser(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		vars := mux.Vars(req)
+		id := vars["id"]
+		user := &models.User{ID: id}
+		if ok, err := server.DB.Exists(user); err != nil || !ok {
+			server.Render.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		if err := server.DB.Update(user, map[string]interface{}{"FailedLogins": 0, "LastFailedLogin": int64(0), "LockedUntil": int64(0)}); err != nil {
 			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the user"})
 			log.Println(err)
 			return
 		}
-		user.Hash = ""
+		audit(server, req, currentUser(req), "user.unlock", "user", id, nil, nil)
+		sanitizeUser(req, user)
 		server.Render.JSON(w, http.StatusOK, user)
 	}
 }
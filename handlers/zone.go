@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/models"
+)
+
+// applyZoneRequest copies zoneReq onto zone, filling in defaults and incrementing the serial.
+func applyZoneRequest(zone *models.Zone, zoneReq *models.ZoneRequest) {
+	zone.Name = models.CanonicalName(zoneReq.Name)
+	zone.NameServers = []models.NameServer{}
+	for _, ns := range zoneReq.NameServers {
+		ns.Name = models.CanonicalName(ns.Name)
+		if ns.A == nil {
+			ns.A = []string{}
+		}
+		if ns.AAAA == nil {
+			ns.AAAA = []string{}
+		}
+		zone.NameServers = append(zone.NameServers, ns)
+	}
+	zone.Mbox = models.CanonicalName(zoneReq.Mbox)
+	if zone.Mbox == "" {
+		zone.Mbox = "hostmaster." + zone.Name
+	}
+	zone.TTL = zoneReq.TTL
+	if zone.TTL == 0 {
+		zone.TTL = models.DefaultZoneTTL
+	}
+	zone.Refresh = zoneReq.Refresh
+	if zone.Refresh == 0 {
+		zone.Refresh = models.DefaultZoneRefresh
+	}
+	zone.Retry = zoneReq.Retry
+	if zone.Retry == 0 {
+		zone.Retry = models.DefaultZoneRetry
+	}
+	zone.Expire = zoneReq.Expire
+	if zone.Expire == 0 {
+		zone.Expire = models.DefaultZoneExpire
+	}
+	zone.Minimum = zoneReq.Minimum
+	if zone.Minimum == 0 {
+		zone.Minimum = models.DefaultZoneMinimum
+	}
+	now := time.Now().Unix()
+	serial := uint32(now)
+	if serial <= zone.Serial {
+		serial = zone.Serial + 1
+	}
+	zone.Serial = serial
+	zone.UpdatedAt = now
+}
+
+// checkZone returns an error message if a record answers or matches a name that zone would
+// answer, since the record would never receive its DNS queries.
+func checkZone(server *app.App, zone *models.Zone) (int, string) {
+	records := []models.Record{}
+	if err := server.DB.All(&records); err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError, "there was an error getting records from the database"
+	}
+	for _, r := range records {
+		if (len(r.Answers) > 0 || r.MatchApex) && answersZone(zone, models.CanonicalName(r.FQDN)) {
+			return http.StatusBadRequest, "the record " + r.FQDN + " has answers or match_apex for a name answered by the zone, remove them first"
+		}
+	}
+	return http.StatusOK, ""
+}
+
+// findZone looks up the zone provided by the mux parameter id, writing a not found response if
+// it does not exist.
+func findZone(server *app.App, w http.ResponseWriter, req *http.Request) (*models.Zone, bool) {
+	vars := mux.Vars(req)
+	zone, err := models.FindZoneByID(server.DB, vars["id"])
+	if err != nil {
+		server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting zone from the database"})
+		log.Println(err)
+		return zone, false
+	}
+	if zone.ID == "" {
+		server.Render.JSON(w, http.StatusNotFound, nil)
+		return zone, false
+	}
+	return zone, true
+}
+
+// CreateZone handles a request to create a new zone.
+func CreateZone(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		zoneReq := &models.ZoneRequest{}
+		if err := binding.Bind(req, zoneReq); err.Handle(w) {
+			return
+		}
+		existing, err := models.FindZoneByName(server.DB, models.CanonicalName(zoneReq.Name))
+		if err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the zone to the database"})
+			log.Println(err)
+			return
+		}
+		if existing.ID != "" {
+			server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "zone name must be unique across the application"})
+			return
+		}
+		zone := &models.Zone{CreatedAt: time.Now().Unix()}
+		applyZoneRequest(zone, zoneReq)
+		if status, msg := checkZone(server, zone); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
+			return
+		}
+		if err := server.DB.Save(zone); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error saving the zone to the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "zone.create", "zone", zone.ID, nil, zone)
+		server.Render.JSON(w, http.StatusCreated, zone)
+	}
+}
+
+// IndexZone handles a request to return a list of all zones.
+func IndexZone(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		zones := []models.Zone{}
+		if err := server.DB.All(&zones); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error getting zones from the database"})
+			log.Println(err)
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, zones)
+	}
+}
+
+// ShowZone handles a request to return a single zone provided by the mux parameter id.
+func ShowZone(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		zone, ok := findZone(server, w, req)
+		if !ok {
+			return
+		}
+		server.Render.JSON(w, http.StatusOK, zone)
+	}
+}
+
+// UpdateZone handles a request to update a single zone provided by the mux parameter id.
+func UpdateZone(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		zone, ok := findZone(server, w, req)
+		if !ok {
+			return
+		}
+		zoneReq := &models.ZoneRequest{}
+		if err := binding.Bind(req, zoneReq); err.Handle(w) {
+			return
+		}
+		if name := models.CanonicalName(zoneReq.Name); name != zone.Name {
+			existing, err := models.FindZoneByName(server.DB, name)
+			if err != nil {
+				server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the zone"})
+				log.Println(err)
+				return
+			}
+			if existing.ID != "" {
+				server.Render.JSON(w, http.StatusBadRequest, map[string]string{"error": "zone name must be unique across the application"})
+				return
+			}
+		}
+		before := *zone
+		applyZoneRequest(zone, zoneReq)
+		if status, msg := checkZone(server, zone); msg != "" {
+			server.Render.JSON(w, status, map[string]string{"error": msg})
+			return
+		}
+		if err := server.DB.Save(zone); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error updating the zone"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "zone.update", "zone", zone.ID, &before, zone)
+		server.Render.JSON(w, http.StatusOK, zone)
+	}
+}
+
+// DeleteZone handles a request to delete a single zone provided by the mux parameter id.
+func DeleteZone(server *app.App) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireRole(server, w, req, models.RoleAdmin) {
+			return
+		}
+		zone, ok := findZone(server, w, req)
+		if !ok {
+			return
+		}
+		if err := server.DB.Delete(zone); err != nil {
+			server.Render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "there was an error deleting the zone from the database"})
+			log.Println(err)
+			return
+		}
+		audit(server, req, currentUser(req), "zone.delete", "zone", zone.ID, zone, nil)
+		server.Render.JSON(w, http.StatusNoContent, nil)
+	}
+}
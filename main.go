@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto/tls"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
@@ -10,10 +14,16 @@ import (
 	"github.com/miekg/dns"
 	"github.com/nlf/boltons"
 	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/auth"
 	"github.com/tomsteele/shellsquid/config"
 	"github.com/tomsteele/shellsquid/handlers"
 	"github.com/tomsteele/shellsquid/middleware"
 	"github.com/tomsteele/shellsquid/models"
+	"github.com/tomsteele/shellsquid/pcap"
+	"github.com/tomsteele/shellsquid/querylog"
+	"github.com/tomsteele/shellsquid/ratelimit"
+	"github.com/tomsteele/shellsquid/stats"
+	"github.com/tomsteele/shellsquid/throttle"
 	"github.com/unrolled/render"
 )
 
@@ -34,6 +44,10 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := models.Migrate(db); err != nil {
+		log.Fatalf("Error migrating db: %s", err.Error())
+	}
+
 	keys, err := db.Keys(models.User{})
 	if err != nil {
 		log.Fatalf("Error getting keys from db: %s", err.Error())
@@ -47,22 +61,70 @@ func main() {
 		firstUser, err := models.NewUser("admin@localhost", []byte(random))
 		if err != nil {
 		}
+		firstUser.Role = models.RoleAdmin
 		if err := db.Save(firstUser); err != nil {
 			log.Fatalf("Error saving first user to db: %s", err.Error())
 		}
 		log.Printf("admin@localhost password set to %s", random)
 	}
 
+	if conf.Proxy.DNS.CaptureDir == "" {
+		conf.Proxy.DNS.CaptureDir = "captures"
+	}
+	captures, err := pcap.NewStore(conf.Proxy.DNS.CaptureDir, conf.Proxy.DNS.CaptureMaxSize*1024*1024)
+	if err != nil {
+		log.Fatalf("Error creating capture directory: %s", err.Error())
+	}
+
+	var queryLog io.Writer
+	if conf.Proxy.DNS.QueryLogFile != "" {
+		queryLogFile, err := os.OpenFile(conf.Proxy.DNS.QueryLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("Error opening DNS query log: %s", err.Error())
+		}
+		defer queryLogFile.Close()
+		queryLog = queryLogFile
+	}
+
+	authenticator := auth.Chain{auth.Local{}}
+	if conf.LDAP.Enabled {
+		authenticator = append(authenticator, &auth.LDAP{Config: conf})
+	}
+
 	serverApp := &app.App{
-		DB:        db,
-		JWTSecret: []byte(conf.JWTKey),
-		Render:    render.New(),
-		Config:    conf,
+		DB:            db,
+		JWTSecret:     []byte(conf.JWTKey),
+		Render:        render.New(),
+		Config:        conf,
+		Captures:      captures,
+		Logins:        throttle.New(time.Second, time.Duration(conf.Login.MaxBackoff)*time.Second, time.Hour),
+		UnknownLogins: throttle.NewAccounts(models.LoginFailureWindow * time.Second),
+		Authenticator: authenticator,
+		RateLimits:    ratelimit.New(10 * time.Minute),
+		Connections:   ratelimit.NewCounter(),
+		Stats:         stats.New(),
+		Queries:       querylog.New(conf.Proxy.DNS.QueryLogSize, queryLog),
+	}
+	if conf.OIDC.Enabled {
+		serverApp.OIDC = auth.NewOIDC(conf)
 	}
 
+	settings, err := models.GetSettings(db)
+	if err != nil {
+		log.Fatalf("Error getting settings from db: %s", err.Error())
+	}
+	if settings.Halted {
+		log.Printf("Halted by %s, no traffic will be routed until resumed", settings.HaltedBy)
+	}
+	serverApp.SetHalted(settings.Halted)
+	handleSignals(serverApp)
+
 	if conf.Proxy.SSL.Enabled {
 		sslMux := http.NewServeMux()
 		sslMux.HandleFunc("/", handlers.Proxy(serverApp, true))
+		if conf.Proxy.DoH.Enabled && conf.Proxy.DoH.Listener == "" {
+			sslMux.HandleFunc("/dns-query", handlers.DoH(serverApp))
+		}
 		sslRecovery := negroni.NewRecovery()
 		sslRecovery.PrintStack = false
 		sslProxy := negroni.New(sslRecovery)
@@ -72,6 +134,22 @@ func main() {
 		}()
 	}
 
+	if conf.Proxy.DoH.Enabled && conf.Proxy.DoH.Listener != "" {
+		if conf.Proxy.DoH.Cert == "" {
+			conf.Proxy.DoH.Cert = conf.Proxy.SSL.Cert
+			conf.Proxy.DoH.Key = conf.Proxy.SSL.Key
+		}
+		dohMux := http.NewServeMux()
+		dohMux.HandleFunc("/dns-query", handlers.DoH(serverApp))
+		dohRecovery := negroni.NewRecovery()
+		dohRecovery.PrintStack = false
+		dohProxy := negroni.New(dohRecovery)
+		dohProxy.UseHandler(dohMux)
+		go func() {
+			log.Fatal(http.ListenAndServeTLS(conf.Proxy.DoH.Listener, conf.Proxy.DoH.Cert, conf.Proxy.DoH.Key, dohProxy))
+		}()
+	}
+
 	if conf.Proxy.HTTP.Enabled {
 		httpMux := http.NewServeMux()
 		httpMux.HandleFunc("/", handlers.Proxy(serverApp, false))
@@ -99,9 +177,33 @@ func main() {
 		}()
 	}
 
+	if conf.Proxy.DoT.Enabled {
+		if conf.Proxy.DoT.Cert == "" {
+			conf.Proxy.DoT.Cert = conf.Proxy.SSL.Cert
+			conf.Proxy.DoT.Key = conf.Proxy.SSL.Key
+		}
+		cert, err := tls.LoadX509KeyPair(conf.Proxy.DoT.Cert, conf.Proxy.DoT.Key)
+		if err != nil {
+			log.Fatalf("Error loading DNS over TLS certificate: %s", err.Error())
+		}
+		tlsDNSServer := &dns.Server{
+			Addr:      conf.Proxy.DoT.Listener,
+			Net:       "tcp-tls",
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			Handler:   dns.HandlerFunc(handlers.ProxyDNS(serverApp)),
+		}
+		go func() {
+			log.Fatal(tlsDNSServer.ListenAndServe())
+		}()
+	}
+
 	r := mux.NewRouter()
 	api := mux.NewRouter()
 	r.HandleFunc("/api/token", handlers.UserToken(serverApp)).Methods("POST")
+	r.HandleFunc("/api/token/refresh", handlers.RefreshToken(serverApp)).Methods("POST")
+	r.HandleFunc("/api/oidc/login", handlers.OIDCLogin(serverApp)).Methods("GET")
+	r.HandleFunc("/api/oidc/callback", handlers.OIDCCallback(serverApp)).Methods("GET")
+	api.HandleFunc("/api/logout", handlers.Logout(serverApp)).Methods("POST")
 
 	api.HandleFunc("/api/users", handlers.CreateUser(serverApp)).Methods("POST")
 	api.HandleFunc("/api/users", handlers.IndexUser(serverApp)).Methods("GET")
@@ -113,11 +215,44 @@ func main() {
 	api.HandleFunc("/api/records/{id}", handlers.ShowRecord(serverApp)).Methods("GET")
 	api.HandleFunc("/api/records/{id}", handlers.DeleteRecord(serverApp)).Methods("DELETE")
 	api.HandleFunc("/api/records/{id}", handlers.UpdateRecord(serverApp)).Methods("PUT")
+	api.HandleFunc("/api/records/{id}/pcap", handlers.ShowRecordCapture(serverApp)).Methods("GET")
+	api.HandleFunc("/api/records/{id}/pcap", handlers.DeleteRecordCapture(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/records/{id}/history", handlers.ShowRecordHistory(serverApp)).Methods("GET")
+	api.HandleFunc("/api/records/{id}/stats", handlers.ShowRecordStats(serverApp)).Methods("GET")
+	api.HandleFunc("/api/records/{id}/rollback", handlers.RollbackRecord(serverApp)).Methods("POST")
+	api.HandleFunc("/api/projects", handlers.CreateProject(serverApp)).Methods("POST")
+	api.HandleFunc("/api/projects", handlers.IndexProject(serverApp)).Methods("GET")
+	api.HandleFunc("/api/projects/{id}", handlers.ShowProject(serverApp)).Methods("GET")
+	api.HandleFunc("/api/projects/{id}", handlers.DeleteProject(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/projects/{id}", handlers.UpdateProject(serverApp)).Methods("PUT")
+	api.HandleFunc("/api/projects/{id}/archive", handlers.ArchiveProject(serverApp)).Methods("POST")
+	api.HandleFunc("/api/zones", handlers.CreateZone(serverApp)).Methods("POST")
+	api.HandleFunc("/api/zones", handlers.IndexZone(serverApp)).Methods("GET")
+	api.HandleFunc("/api/zones/{id}", handlers.ShowZone(serverApp)).Methods("GET")
+	api.HandleFunc("/api/zones/{id}", handlers.DeleteZone(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/zones/{id}", handlers.UpdateZone(serverApp)).Methods("PUT")
+	api.HandleFunc("/api/keys", handlers.CreateAPIKey(serverApp)).Methods("POST")
+	api.HandleFunc("/api/keys", handlers.IndexAPIKey(serverApp)).Methods("GET")
+	api.HandleFunc("/api/keys/{id}", handlers.DeleteAPIKey(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/users/{id}/unlock", handlers.UnlockUser(serverApp)).Methods("POST")
+	api.HandleFunc("/api/users/{id}/totp", handlers.ResetUserTOTP(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/totp", handlers.EnrollTOTP(serverApp)).Methods("POST")
+	api.HandleFunc("/api/totp", handlers.DisableTOTP(serverApp)).Methods("DELETE")
+	api.HandleFunc("/api/totp/verify", handlers.VerifyTOTP(serverApp)).Methods("POST")
+	api.HandleFunc("/api/settings", handlers.ShowSettings(serverApp)).Methods("GET")
+	api.HandleFunc("/api/settings", handlers.UpdateSettings(serverApp)).Methods("PUT")
+	api.HandleFunc("/api/halt", handlers.Halt(serverApp)).Methods("POST")
+	api.HandleFunc("/api/resume", handlers.Resume(serverApp)).Methods("POST")
+	api.HandleFunc("/api/audit", handlers.IndexAudit(serverApp)).Methods("GET")
+	api.HandleFunc("/api/dns/queries", handlers.IndexDNSQueries(serverApp)).Methods("GET")
+	api.HandleFunc("/api/dns/queries/aggregate", handlers.AggregateDNSQueries(serverApp)).Methods("GET")
 	api.HandleFunc("/api/info", handlers.Info(serverApp, version)).Methods("GET")
 
 	r.PathPrefix("/api").Handler(negroni.New(
+		negroni.HandlerFunc(middleware.APIKeyAuth(serverApp)),
 		negroni.HandlerFunc(middleware.JWTAuth(serverApp)),
 		negroni.HandlerFunc(middleware.SetUserContext(serverApp)),
+		negroni.HandlerFunc(middleware.RequireTOTP(serverApp)),
 		negroni.Wrap(api),
 	))
 
@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/auth0/go-jwt-middleware"
 	"github.com/dgrijalva/jwt-go"
@@ -10,13 +14,34 @@ import (
 	"github.com/tomsteele/shellsquid/models"
 )
 
-// SetUserContext takes a JWT token from the mux context and looks up the user by id. The user
-// is then set into the same context.
-func SetUserContext(server *app.App) func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+// apiKeyFromRequest returns an API key provided in the X-API-Key header or as a bearer token.
+func apiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	parts := strings.Split(req.Header.Get("Authorization"), " ")
+	if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" && strings.HasPrefix(parts[1], models.APIKeyPrefix) {
+		return parts[1]
+	}
+	return ""
+}
+
+// APIKeyAuth authenticates requests that provide an API key instead of a JWT. The key's user and
+// role are set into the context along with the key itself, and the JWT middleware is skipped.
+// Read-only keys may only be used for GET and HEAD requests.
+func APIKeyAuth(server *app.App) func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-		token := context.Get(req, "user").(*jwt.Token)
-		id := token.Claims["id"].(string)
-		user := &models.User{ID: id}
+		plaintext := apiKeyFromRequest(req)
+		if plaintext == "" {
+			next(w, req)
+			return
+		}
+		key, err := models.FindAPIKey(server.DB, plaintext)
+		if err != nil || key.ID == "" || key.Expired() {
+			server.Render.JSON(w, http.StatusUnauthorized, nil)
+			return
+		}
+		user := &models.User{ID: key.UserID}
 		if ok, err := server.DB.Exists(user); err != nil || !ok {
 			server.Render.JSON(w, http.StatusUnauthorized, nil)
 			return
@@ -25,17 +50,98 @@ func SetUserContext(server *app.App) func(w http.ResponseWriter, req *http.Reque
 			server.Render.JSON(w, http.StatusUnauthorized, nil)
 			return
 		}
+		if key.ReadOnly() && req.Method != "GET" && req.Method != "HEAD" {
+			server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "this API key is read-only"})
+			return
+		}
+		if key.Used(time.Now().Unix()) {
+			if err := server.DB.Update(key, map[string]interface{}{"LastUsedAt": key.LastUsedAt}); err != nil {
+				log.Println(err)
+			}
+		}
+		context.Set(req, "apikey", key)
 		context.Set(req, "user", user)
+		context.Set(req, "role", user.Role)
 		next(w, req)
 	}
 }
 
-// JWTAuth parses a JWT token from an authorization header.
+// SetUserContext takes a JWT token from the mux context and looks up the user by id. Tokens
+// that have been revoked or issued before the user's token version changed are rejected. The
+// user and their role are then set into the same context, the token is kept as token.
+func SetUserContext(server *app.App) func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		if _, ok := context.Get(req, "apikey").(*models.APIKey); ok {
+			next(w, req)
+			return
+		}
+		token := context.Get(req, "user").(*jwt.Token)
+		user, err := models.ValidateTokenClaims(server.DB, token.Claims)
+		if err != nil {
+			server.Render.JSON(w, http.StatusUnauthorized, nil)
+			return
+		}
+		context.Set(req, "token", token)
+		context.Set(req, "user", user)
+		context.Set(req, "role", user.Role)
+		next(w, req)
+	}
+}
+
+// JWTAuth parses a JWT token from an authorization header. Only HS256 signed access tokens are accepted.
+// Requests already authenticated by APIKeyAuth are passed through.
 func JWTAuth(server *app.App) func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	j := jwtmiddleware.New(jwtmiddleware.Options{
 		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, errors.New("unexpected signing method")
+			}
+			if typ, _ := token.Claims["typ"].(string); typ != "access" {
+				return nil, errors.New("token is not an access token")
+			}
+			if _, ok := token.Claims["exp"].(float64); !ok {
+				return nil, errors.New("token does not expire")
+			}
 			return server.JWTSecret, nil
 		},
 	})
-	return j.HandlerWithNext
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if _, ok := context.Get(r, "apikey").(*models.APIKey); ok {
+			next(w, r)
+			return
+		}
+		j.HandlerWithNext(w, r, next)
+	}
+}
+
+// totpExempt are the paths a user who has not enrolled in TOTP may still use when enrollment is required.
+var totpExempt = map[string]bool{
+	"/api/totp":        true,
+	"/api/totp/verify": true,
+	"/api/logout":      true,
+	"/api/info":        true,
+}
+
+// RequireTOTP rejects requests from users who have not enrolled in TOTP when an admin has made
+// two-factor authentication mandatory. Users may still reach the endpoints needed to enroll. Users
+// who log in through OIDC are exempt, their second factor is left to the identity provider.
+func RequireTOTP(server *app.App) func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		user := context.Get(req, "user").(*models.User)
+		if user.TOTPEnabled || user.Source == models.SourceOIDC || totpExempt[req.URL.Path] {
+			next(w, req)
+			return
+		}
+		settings, err := models.GetSettings(server.DB)
+		if err != nil {
+			log.Println(err)
+			server.Render.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if settings.RequireTOTP {
+			server.Render.JSON(w, http.StatusForbidden, map[string]string{"error": "two-factor authentication must be enabled before using the API"})
+			return
+		}
+		next(w, req)
+	}
 }
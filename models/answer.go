@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mholt/binding"
+)
+
+// ProtocolStatic is the handler protocol of records that only answer DNS queries from their
+// Answers and are not proxied.
+const ProtocolStatic = "static"
+
+// DefaultAnswerTTL is the TTL, in seconds, of answers that do not set one.
+const DefaultAnswerTTL = 300
+
+// Answer types supported by static DNS answers.
+const (
+	AnswerA     = "A"
+	AnswerAAAA  = "AAAA"
+	AnswerCNAME = "CNAME"
+	AnswerTXT   = "TXT"
+	AnswerMX    = "MX"
+)
+
+// Answer is static data returned for DNS queries of the FQDN of a record. Value is an address
+// for A and AAAA, a hostname for CNAME and MX, and text for TXT. Preference is only used by MX.
+type Answer struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	TTL        uint32 `json:"ttl"`
+	Preference uint16 `json:"preference"`
+}
+
+// AnswerTTL returns the TTL of the answer or the default.
+func (a Answer) AnswerTTL() uint32 {
+	if a.TTL == 0 {
+		return DefaultAnswerTTL
+	}
+	return a.TTL
+}
+
+// validateAnswers validates the static answers, wildcard and apex options of a record.
+func validateAnswers(protocol string, answers []Answer, wildcard, matchApex bool, errs binding.Errors) binding.Errors {
+	if protocol == ProtocolStatic && len(answers) == 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"answers"},
+			Message:    "static records require at least one answer",
+		})
+	}
+	if wildcard && len(answers) == 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"wildcard"},
+			Message:    "wildcard requires at least one answer",
+		})
+	}
+	if wildcard && protocol == "dns" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"wildcard"},
+			Message:    "wildcard can not be used with dns records, their subdomains are proxied",
+		})
+	}
+	if matchApex && protocol != "dns" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"match_apex"},
+			Message:    "match_apex can only be used with dns records",
+		})
+	}
+	if matchApex && len(answers) > 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"match_apex"},
+			Message:    "match_apex can not be combined with answers, the fqdn would be both answered and proxied",
+		})
+	}
+	return validateAnswerList("answers", answers, errs)
+}
+
+// validateAnswerList appends an error for each invalid answer, field is the name of the list
+// in the request.
+func validateAnswerList(field string, answers []Answer, errs binding.Errors) binding.Errors {
+	cnames := 0
+	for i, a := range answers {
+		name := fmt.Sprintf("%s.%d", field, i)
+		var msg string
+		switch a.Type {
+		case AnswerA:
+			if ip := net.ParseIP(a.Value); ip == nil || ip.To4() == nil {
+				msg = "A answers must be an IPv4 address"
+			}
+		case AnswerAAAA:
+			if ip := net.ParseIP(a.Value); ip == nil || ip.To4() != nil {
+				msg = "AAAA answers must be an IPv6 address"
+			}
+		case AnswerCNAME, AnswerMX:
+			if !validHostname(CanonicalName(a.Value)) {
+				msg = a.Type + " answers must be a valid hostname"
+			}
+			if a.Type == AnswerCNAME {
+				cnames++
+			}
+		case AnswerTXT:
+			if a.Value == "" {
+				msg = "TXT answers must not be empty"
+			}
+		default:
+			msg = "answer type must be one of A, AAAA, CNAME, TXT, or MX"
+		}
+		if msg != "" {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{name},
+				Message:    msg,
+			})
+		}
+	}
+	if cnames > 0 && len(answers) > 1 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{field},
+			Message:    "a CNAME answer can not be combined with other answers",
+		})
+	}
+	return errs
+}
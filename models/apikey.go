@@ -0,0 +1,183 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmcvetta/randutil"
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// APIKeyPrefix starts every API key so that it can be told apart from a JWT.
+const APIKeyPrefix = "ssq_"
+
+// APIKeyUsageInterval is how often, in seconds, the last use of an API key is saved.
+const APIKeyUsageInterval = 60
+
+// Scopes an API key may be granted.
+const (
+	ScopeReadOnly  = "read-only"
+	ScopeReadWrite = "read-write"
+)
+
+// APIKey is a long-lived credential used by automation in place of a JWT. Only a hash of the
+// key is stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID         string `json:"id"`
+	UserID     string `json:"user_id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	Hash       string `json:"-"`
+	Scope      string `json:"scope"`
+	ExpiresAt  int64  `json:"expires_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// Expired returns true if the key has an expiration that has passed.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != 0 && k.ExpiresAt < time.Now().Unix()
+}
+
+// Used records that the key was used at now. It returns false if the last use was saved less
+// than APIKeyUsageInterval seconds ago and does not need to be saved again.
+func (k *APIKey) Used(now int64) bool {
+	if now-k.LastUsedAt < APIKeyUsageInterval {
+		return false
+	}
+	k.LastUsedAt = now
+	return true
+}
+
+// ReadOnly returns true if the key may only be used for requests that do not modify data.
+func (k *APIKey) ReadOnly() bool {
+	return k.Scope != ScopeReadWrite
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey creates and returns a new API key for the user along with the plaintext key.
+func NewAPIKey(userID, name, scope string, expiresAt int64) (*APIKey, string, error) {
+	key := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().Unix(),
+	}
+	secret, err := randutil.AlphaString(40)
+	if err != nil {
+		return key, "", err
+	}
+	plaintext := APIKeyPrefix + secret
+	key.Prefix = plaintext[:len(APIKeyPrefix)+6]
+	key.Hash = hashAPIKey(plaintext)
+	return key, plaintext, nil
+}
+
+// FindAPIKey returns the stored key matching the plaintext key. If no key is found the returned
+// key has an empty ID.
+func FindAPIKey(db *boltons.DB, plaintext string) (*APIKey, error) {
+	key := APIKey{}
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) {
+		return &key, nil
+	}
+	keys := []APIKey{}
+	if err := db.All(&keys); err != nil {
+		return &key, err
+	}
+	hash := []byte(hashAPIKey(plaintext))
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), hash) == 1 {
+			return &k, nil
+		}
+	}
+	return &key, nil
+}
+
+// FindAPIKeyByID returns a single key for the provided id.
+func FindAPIKeyByID(db *boltons.DB, ID string) (*APIKey, error) {
+	key := APIKey{}
+	keys := []APIKey{}
+	if err := db.All(&keys); err != nil {
+		return &key, err
+	}
+	for _, k := range keys {
+		if k.ID == ID {
+			return &k, nil
+		}
+	}
+	return &key, nil
+}
+
+// FindAPIKeysForUser returns every key belonging to the user id. If ID is empty all keys are returned.
+func FindAPIKeysForUser(db *boltons.DB, ID string) ([]APIKey, error) {
+	keys := []APIKey{}
+	foundKeys := []APIKey{}
+	if err := db.All(&keys); err != nil {
+		return foundKeys, err
+	}
+	for _, k := range keys {
+		if ID == "" || k.UserID == ID {
+			foundKeys = append(foundKeys, k)
+		}
+	}
+	return foundKeys, nil
+}
+
+// DeleteAPIKeysForUser removes every key belonging to the user id.
+func DeleteAPIKeysForUser(db *boltons.DB, ID string) error {
+	keys, err := FindAPIKeysForUser(db, ID)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := db.Delete(&keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// APIKeyRequest is used for JSON binding when creating a new API key.
+type APIKeyRequest struct {
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// FieldMap implements binding.FieldMap
+func (k *APIKeyRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload to create an API key.
+func (k *APIKeyRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if k.Name == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"name"},
+			Message:    "name is required",
+		})
+	}
+	if k.Scope != ScopeReadOnly && k.Scope != ScopeReadWrite {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"scope"},
+			Message:    "scope must be either read-only or read-write",
+		})
+	}
+	if k.ExpiresAt != 0 && k.ExpiresAt <= time.Now().Unix() {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"expires_at"},
+			Message:    "expires_at must be in the future",
+		})
+	}
+	return errs
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlf/boltons"
+)
+
+// AuditEntry records a single change made through the API. Entries are only ever appended,
+// there is no way to modify or remove one through the API.
+type AuditEntry struct {
+	ID         string                 `json:"id"`
+	Time       int64                  `json:"time"`
+	ActorID    string                 `json:"actor_id"`
+	Actor      string                 `json:"actor"`
+	APIKeyID   string                 `json:"api_key_id,omitempty"`
+	IP         string                 `json:"ip"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	Before     map[string]interface{} `json:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty"`
+}
+
+var (
+	sequenceMu   sync.Mutex
+	sequenceLast int64
+)
+
+// sequentialID returns an id that sorts after every id previously returned, so that entries
+// are stored in the order they happened.
+func sequentialID(now time.Time) string {
+	sequenceMu.Lock()
+	defer sequenceMu.Unlock()
+	n := now.UnixNano()
+	if n <= sequenceLast {
+		n = sequenceLast + 1
+	}
+	sequenceLast = n
+	return fmt.Sprintf("%020d", n)
+}
+
+// toMap converts v to a map using its JSON representation, so that fields hidden from the API
+// are never written to the audit log. A nil v returns a nil map.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AuditDiff returns the fields of before and after that differ. If either is nil the other is
+// returned in full, as happens when something is created or deleted.
+func AuditDiff(before, after interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+	for k, v := range b {
+		if av, ok := a[k]; ok && reflect.DeepEqual(v, av) {
+			delete(b, k)
+			delete(a, k)
+		}
+	}
+	return b, a, nil
+}
+
+// AppendAudit stores entry, setting its id and time.
+func AppendAudit(db *boltons.DB, entry *AuditEntry) error {
+	now := time.Now()
+	entry.ID = sequentialID(now)
+	entry.Time = now.Unix()
+	return db.Save(entry)
+}
+
+// AuditFilter selects audit entries. Empty fields match every entry.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	IP         string
+	Since      int64
+	Until      int64
+}
+
+// Match returns true if entry satisfies every field of the filter. Actor matches either the
+// actor's id or email, and an action ending in "." matches every action with that prefix.
+func (f AuditFilter) Match(entry *AuditEntry) bool {
+	if f.Actor != "" && f.Actor != entry.ActorID && !strings.EqualFold(f.Actor, entry.Actor) {
+		return false
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(entry.Action, f.Action) {
+				return false
+			}
+		} else if f.Action != entry.Action {
+			return false
+		}
+	}
+	if f.TargetType != "" && f.TargetType != entry.TargetType {
+		return false
+	}
+	if f.TargetID != "" && f.TargetID != entry.TargetID {
+		return false
+	}
+	if f.IP != "" && f.IP != entry.IP {
+		return false
+	}
+	if f.Since != 0 && entry.Time < f.Since {
+		return false
+	}
+	if f.Until != 0 && entry.Time > f.Until {
+		return false
+	}
+	return true
+}
+
+// FindAuditEntries returns the entries matching filter, oldest first.
+func FindAuditEntries(db *boltons.DB, filter AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	found := []AuditEntry{}
+	if err := db.All(&entries); err != nil {
+		return found, err
+	}
+	for i := range entries {
+		if filter.Match(&entries[i]) {
+			found = append(found, entries[i])
+		}
+	}
+	return found, nil
+}
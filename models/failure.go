@@ -0,0 +1,59 @@
+package models
+
+import "github.com/mholt/binding"
+
+// Responses to DNS queries that do not match a record, or whose record is blacklisted or
+// outside its active period.
+const (
+	// FailureServFail responds with SERVFAIL, the default.
+	FailureServFail = "servfail"
+	// FailureNXDomain responds that the name does not exist, with a SOA in the authority section.
+	FailureNXDomain = "nxdomain"
+	// FailureRefused responds with REFUSED.
+	FailureRefused = "refused"
+	// FailureNoData responds that the name exists without data of the requested type.
+	FailureNoData = "nodata"
+	// FailureDecoy responds with the static Answers of the failure.
+	FailureDecoy = "decoy"
+	// FailureDrop does not respond at all.
+	FailureDrop = "drop"
+)
+
+// Failure configures how a DNS query that can not be routed is answered. Answers are only used
+// by FailureDecoy.
+type Failure struct {
+	Action  string   `json:"action"`
+	Answers []Answer `json:"answers"`
+}
+
+// Or returns f, or fallback if f does not set an action, defaulting to FailureServFail.
+func (f Failure) Or(fallback Failure) Failure {
+	if f.Action != "" {
+		return f
+	}
+	if fallback.Action != "" {
+		return fallback
+	}
+	return Failure{Action: FailureServFail}
+}
+
+// validateFailure appends an error for each invalid option of a failure, field is the name of
+// the failure in the request.
+func validateFailure(field string, f Failure, errs binding.Errors) binding.Errors {
+	switch f.Action {
+	case "", FailureServFail, FailureNXDomain, FailureRefused, FailureNoData, FailureDrop:
+	case FailureDecoy:
+		if len(f.Answers) == 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{field + ".answers"},
+				Message:    "decoy requires at least one answer",
+			})
+		}
+	default:
+		errs = append(errs, binding.Error{
+			FieldNames: []string{field + ".action"},
+			Message:    field + ".action must be one of servfail, nxdomain, refused, nodata, decoy, or drop",
+		})
+	}
+	return validateAnswerList(field+".answers", f.Answers, errs)
+}
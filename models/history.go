@@ -0,0 +1,133 @@
+package models
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// RecordVersion is a copy of a record as it was after a change. Every change to a record
+// stores a new version so that earlier handler settings can be restored. Versions are kept
+// after the record is deleted, the last one has the delete action and holds the record as
+// it was deleted.
+type RecordVersion struct {
+	ID        string   `json:"id"`
+	RecordID  string   `json:"record_id"`
+	Version   int      `json:"version"`
+	Action    string   `json:"action"`
+	Changes   []string `json:"changes"`
+	UserID    string   `json:"user_id"`
+	UserEmail string   `json:"user_email"`
+	Record    Record   `json:"record"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// versionIgnored are fields that change with every version and are left out of Changes.
+var versionIgnored = map[string]bool{
+	"version":    true,
+	"updated_at": true,
+}
+
+// AddRecordVersion stores record as a new version in its history. prev is the record before the
+// change and is used to list the fields that changed, it is nil when the record is created.
+// user is the user that made the change, if any.
+func AddRecordVersion(db *boltons.DB, record, prev *Record, user *User, action string) error {
+	now := time.Now()
+	version := &RecordVersion{
+		ID:        sequentialID(now),
+		RecordID:  record.ID,
+		Version:   record.Version,
+		Action:    action,
+		Changes:   []string{},
+		Record:    *record,
+		CreatedAt: now.Unix(),
+	}
+	if user != nil {
+		version.UserID = user.ID
+		version.UserEmail = user.Email
+	}
+	if prev != nil {
+		_, after, err := AuditDiff(prev, record)
+		if err != nil {
+			return err
+		}
+		for field := range after {
+			if !versionIgnored[field] {
+				version.Changes = append(version.Changes, field)
+			}
+		}
+		sort.Strings(version.Changes)
+	}
+	return db.Save(version)
+}
+
+// FindRecordHistory returns every version of the record id, oldest first.
+func FindRecordHistory(db *boltons.DB, ID string) ([]RecordVersion, error) {
+	versions := []RecordVersion{}
+	found := []RecordVersion{}
+	if err := db.All(&versions); err != nil {
+		return found, err
+	}
+	for _, v := range versions {
+		if v.RecordID == ID {
+			found = append(found, v)
+		}
+	}
+	sort.Sort(byVersion(found))
+	return found, nil
+}
+
+// FindRecordVersion returns a single version of the record id. If it does not exist the
+// returned version has an empty ID.
+func FindRecordVersion(db *boltons.DB, ID string, number int) (*RecordVersion, error) {
+	versions, err := FindRecordHistory(db, ID)
+	if err != nil {
+		return &RecordVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Version == number {
+			return &v, nil
+		}
+	}
+	return &RecordVersion{}, nil
+}
+
+// FindDeletedRecord returns the record id as it was when it was deleted, from the last version
+// in its history. If the record was not deleted the returned record has an empty ID.
+func FindDeletedRecord(db *boltons.DB, ID string) (*Record, error) {
+	versions, err := FindRecordHistory(db, ID)
+	if err != nil || len(versions) == 0 || versions[len(versions)-1].Action != "delete" {
+		return &Record{}, err
+	}
+	return &versions[len(versions)-1].Record, nil
+}
+
+type byVersion []RecordVersion
+
+func (v byVersion) Len() int           { return len(v) }
+func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byVersion) Less(i, j int) bool { return v[i].Version < v[j].Version }
+
+// RollbackRequest is used for JSON binding when restoring a previous version of a record.
+type RollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// FieldMap implements binding.FieldMap
+func (r *RollbackRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload to restore a previous version of a record.
+func (r *RollbackRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if r.Version < 1 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"version"},
+			Message:    "version is required",
+		})
+	}
+	return errs
+}
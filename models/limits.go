@@ -0,0 +1,51 @@
+package models
+
+import "github.com/mholt/binding"
+
+// Actions a proxy may take when a request is over one of a record's limits.
+const (
+	// LimitReject responds with 429 Too Many Requests, or REFUSED for DNS.
+	LimitReject = "reject"
+	// LimitDrop closes the connection, or for DNS does not answer, without a response.
+	LimitDrop = "drop"
+	// LimitFallback responds as if no record matched.
+	LimitFallback = "fallback"
+)
+
+// Limits protects a record's handler from clients that send too much traffic. Rates are in
+// requests per second, bursts are the number of requests allowed at once. A zero value
+// disables that limit.
+type Limits struct {
+	Rate          float64 `json:"rate"`
+	Burst         int     `json:"burst"`
+	IPRate        float64 `json:"ip_rate"`
+	IPBurst       int     `json:"ip_burst"`
+	Connections   int     `json:"connections"`
+	IPConnections int     `json:"ip_connections"`
+	Action        string  `json:"action"`
+}
+
+// OverLimitAction returns the action to take when a request is over a limit, defaulting to LimitReject.
+func (l Limits) OverLimitAction() string {
+	if l.Action == "" {
+		return LimitReject
+	}
+	return l.Action
+}
+
+// validateLimits appends an error for each invalid limit of a record request.
+func validateLimits(l Limits, errs binding.Errors) binding.Errors {
+	if l.Rate < 0 || l.Burst < 0 || l.IPRate < 0 || l.IPBurst < 0 || l.Connections < 0 || l.IPConnections < 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"limits"},
+			Message:    "limits must not be negative",
+		})
+	}
+	if l.Action != "" && l.Action != LimitReject && l.Action != LimitDrop && l.Action != LimitFallback {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"limits.action"},
+			Message:    "limits.action must be either reject, drop, or fallback",
+		})
+	}
+	return errs
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"reflect"
+
+	"github.com/nlf/boltons"
+)
+
+// Migrate saves every user, record, and other row that is changed field by field back to the
+// database so that fields added since they were first stored are present when they are fetched
+// individually, and creates the buckets of every model so that lookups in them do not fail.
+// Users created before roles existed were all admins and keep that role, users created
+// before authentication sources existed are local. Records created before history was kept
+// start their history with their current state.
+func Migrate(db *boltons.DB) error {
+	if err := createBuckets(db, &User{}, &Record{}); err != nil {
+		return err
+	}
+	users := []User{}
+	if err := db.All(&users); err != nil {
+		return err
+	}
+	for i := range users {
+		if users[i].Role == "" {
+			users[i].Role = RoleAdmin
+		}
+		if users[i].Source == "" {
+			users[i].Source = SourceLocal
+		}
+		if err := db.Save(&users[i]); err != nil {
+			return err
+		}
+	}
+	records := []Record{}
+	if err := db.All(&records); err != nil {
+		return err
+	}
+	for i := range records {
+		if records[i].Version == 0 {
+			records[i].Version = 1
+			owner := &User{ID: records[i].Owner.ID, Email: records[i].Owner.Email}
+			if err := AddRecordVersion(db, &records[i], nil, owner, "import"); err != nil {
+				return err
+			}
+		}
+		if err := db.Save(&records[i]); err != nil {
+			return err
+		}
+	}
+	if err := resave(db,
+		&[]Project{},
+		&[]APIKey{},
+		&[]Settings{},
+		&[]Zone{},
+	); err != nil {
+		return err
+	}
+	// Logs are only appended to and read in full, they only need their bucket.
+	return createBuckets(db,
+		&RevokedToken{},
+		&AuditEntry{},
+		&RecordVersion{},
+	)
+}
+
+// resave saves every row of each slice pointed to by rows back to the database, creating the
+// bucket of a model without rows.
+func resave(db *boltons.DB, rows ...interface{}) error {
+	for _, r := range rows {
+		if err := db.All(r); err != nil {
+			return err
+		}
+		v := reflect.ValueOf(r).Elem()
+		if v.Len() == 0 {
+			if err := createBuckets(db, reflect.New(v.Type().Elem()).Interface()); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := db.Save(v.Index(i).Addr().Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createBuckets creates the bucket of each of models if it does not exist. boltons only creates
+// a bucket when a row is saved, and panics when a row is looked up by id in a missing bucket.
+func createBuckets(db *boltons.DB, models ...interface{}) error {
+	for _, m := range models {
+		keys, err := db.Keys(m)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			continue
+		}
+		if err := db.Save(m); err != nil {
+			return err
+		}
+		if err := db.Delete(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
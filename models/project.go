@@ -0,0 +1,175 @@
+package models
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// Project groups the records and users of a single engagement.
+type Project struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+	Archived    bool     `json:"archived"`
+	CreatedAt   int64    `json:"created_at"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+// HasMember returns true if user is a member of the project. Admins are members of every project.
+func (p *Project) HasMember(user *User) bool {
+	if user.IsAdmin() {
+		return true
+	}
+	for _, id := range p.Members {
+		if id == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// FindProjectByID returns a single project for the provided id. If no project is found
+// the returned project has an empty ID.
+func FindProjectByID(db *boltons.DB, ID string) (*Project, error) {
+	project := Project{}
+	projects := []Project{}
+	if err := db.All(&projects); err != nil {
+		return &project, err
+	}
+	for _, p := range projects {
+		if p.ID == ID {
+			return &p, nil
+		}
+	}
+	return &project, nil
+}
+
+// FindProjectByName returns a single project for the provided name.
+func FindProjectByName(db *boltons.DB, name string) (*Project, error) {
+	project := Project{}
+	projects := []Project{}
+	if err := db.All(&projects); err != nil {
+		return &project, err
+	}
+	for _, p := range projects {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return &project, nil
+}
+
+// FindProjectsForUser returns every project the user is a member of.
+func FindProjectsForUser(db *boltons.DB, user *User) ([]Project, error) {
+	projects := []Project{}
+	foundProjects := []Project{}
+	if err := db.All(&projects); err != nil {
+		return foundProjects, err
+	}
+	for _, p := range projects {
+		if p.HasMember(user) {
+			foundProjects = append(foundProjects, p)
+		}
+	}
+	return foundProjects, nil
+}
+
+// FindRecordsForProject returns a list of all records in a project by its id.
+func FindRecordsForProject(db *boltons.DB, ID string) ([]Record, error) {
+	records := []Record{}
+	foundRecords := []Record{}
+	if err := db.All(&records); err != nil {
+		return foundRecords, err
+	}
+	for _, r := range records {
+		if r.ProjectID == ID {
+			foundRecords = append(foundRecords, r)
+		}
+	}
+	return foundRecords, nil
+}
+
+// FindRecordsForUser returns the records visible to user. Records that are not part of a
+// project are visible to everyone, all others only to members of their project.
+func FindRecordsForUser(db *boltons.DB, user *User) ([]Record, error) {
+	records := []Record{}
+	foundRecords := []Record{}
+	if err := db.All(&records); err != nil {
+		return foundRecords, err
+	}
+	projects, err := FindProjectsForUser(db, user)
+	if err != nil {
+		return foundRecords, err
+	}
+	member := map[string]bool{"": true}
+	for _, p := range projects {
+		member[p.ID] = true
+	}
+	for _, r := range records {
+		if member[r.ProjectID] {
+			foundRecords = append(foundRecords, r)
+		}
+	}
+	return foundRecords, nil
+}
+
+// RecordVisible returns true if user may view record.
+func RecordVisible(db *boltons.DB, user *User, record *Record) (bool, error) {
+	if record.ProjectID == "" || user.IsAdmin() {
+		return true, nil
+	}
+	project, err := FindProjectByID(db, record.ProjectID)
+	if err != nil {
+		return false, err
+	}
+	return project.HasMember(user), nil
+}
+
+// RemoveUserFromProjects removes the user id from the members of every project.
+func RemoveUserFromProjects(db *boltons.DB, ID string) error {
+	projects := []Project{}
+	if err := db.All(&projects); err != nil {
+		return err
+	}
+	for i := range projects {
+		members := []string{}
+		for _, id := range projects[i].Members {
+			if id != ID {
+				members = append(members, id)
+			}
+		}
+		if len(members) == len(projects[i].Members) {
+			continue
+		}
+		if err := db.Update(&projects[i], map[string]interface{}{"Members": members}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProjectRequest is used for JSON binding when creating or updating a project.
+type ProjectRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+}
+
+// FieldMap implements binding.FieldMap
+func (p *ProjectRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload for a project.
+func (p *ProjectRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if p.Name == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"name"},
+			Message:    "name is required",
+		})
+	}
+	return errs
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/mholt/binding"
+	"github.com/miekg/dns"
+)
+
+// DefaultEDNSBufferSize is the UDP payload size, in bytes, advertised with EDNS0 if a record
+// does not set one. It avoids IP fragmentation on common networks.
+const DefaultEDNSBufferSize = 1232
+
+// EDNS controls the EDNS0 handling of DNS queries for a record. BufferSize is advertised to
+// clients and to the handler, responses larger than the size a UDP client advertised are
+// truncated. The client subnet option is removed from queries before they are forwarded to the
+// handler unless ClientSubnet is set.
+type EDNS struct {
+	BufferSize   uint16 `json:"buffer_size"`
+	ClientSubnet bool   `json:"client_subnet"`
+}
+
+// EDNSBufferSize returns the advertised UDP payload size, or the default.
+func (e EDNS) EDNSBufferSize() uint16 {
+	if e.BufferSize == 0 {
+		return DefaultEDNSBufferSize
+	}
+	return e.BufferSize
+}
+
+// AllowsType returns true if DNS queries of qtype may be routed to the record. Every type is
+// allowed if QueryTypes is empty.
+func (r *Record) AllowsType(qtype uint16) bool {
+	if len(r.QueryTypes) == 0 {
+		return true
+	}
+	for _, t := range r.QueryTypes {
+		if dns.StringToType[strings.ToUpper(t)] == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// validateQuery appends an error for each invalid query type or EDNS option of a record request.
+func validateQuery(types []string, edns EDNS, errs binding.Errors) binding.Errors {
+	for _, t := range types {
+		qtype, ok := dns.StringToType[strings.ToUpper(t)]
+		if !ok || qtype == dns.TypeANY || qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"query_types"},
+				Message:    "query_types must be DNS record types such as TXT, other than ANY, AXFR, or IXFR",
+			})
+			break
+		}
+	}
+	if edns.BufferSize != 0 && edns.BufferSize < dns.MinMsgSize {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"edns.buffer_size"},
+			Message:    "edns.buffer_size must be at least 512",
+		})
+	}
+	return errs
+}
+
+// validateRewrite appends an error if the rewrite domain of a record request is invalid.
+func validateRewrite(protocol, rewriteTo string, errs binding.Errors) binding.Errors {
+	if rewriteTo == "" {
+		return errs
+	}
+	if protocol != "dns" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"rewrite_to"},
+			Message:    "rewrite_to can only be used with dns records",
+		})
+	}
+	if !validHostname(CanonicalName(rewriteTo)) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"rewrite_to"},
+			Message:    "rewrite_to must be a valid domain name",
+		})
+	}
+	return errs
+}
@@ -3,7 +3,9 @@ package models
 import (
 	"net/http"
 	"regexp"
+	"strings"
 
+	"github.com/jinzhu/copier"
 	"github.com/mholt/binding"
 	"github.com/nlf/boltons"
 )
@@ -15,13 +17,89 @@ type Record struct {
 		ID    string `json:"id"`
 		Email string `json:"email"`
 	} `json:"owner"`
-	FQDN            string `json:"fqdn"`
-	HandlerHost     string `json:"handler_host"`
-	HandlerPort     int    `json:"handler_port"`
-	HandlerProtocol string `json:"handler_protocol"`
-	UpdatedAt       int64  `json:"updated_at"`
-	CreatedAt       int64  `json:"created_at"`
-	Blacklist       bool   `json:"blacklist"`
+	FQDN            string   `json:"fqdn"`
+	HandlerHost     string   `json:"handler_host"`
+	HandlerPort     int      `json:"handler_port"`
+	HandlerProtocol string   `json:"handler_protocol"`
+	UpdatedAt       int64    `json:"updated_at"`
+	CreatedAt       int64    `json:"created_at"`
+	Blacklist       bool     `json:"blacklist"`
+	Capture         bool     `json:"capture"`
+	SharedWith      []string `json:"shared_with"`
+	ProjectID       string   `json:"project_id"`
+	// Version is incremented on every change, see RecordVersion.
+	Version int `json:"version"`
+	// ActiveFrom and ActiveUntil are unix timestamps limiting when traffic is routed, zero
+	// means no limit. Windows further limit routing to recurring periods in Timezone.
+	ActiveFrom  int64    `json:"active_from"`
+	ActiveUntil int64    `json:"active_until"`
+	Windows     []Window `json:"windows"`
+	Timezone    string   `json:"timezone"`
+	Limits      Limits   `json:"limits"`
+	// Answers are returned for DNS queries of FQDN, and of its subdomains if Wildcard is set.
+	Answers  []Answer `json:"answers"`
+	Wildcard bool     `json:"wildcard"`
+	// MatchApex routes DNS queries for FQDN itself, not only its subdomains, to a dns handler.
+	MatchApex bool `json:"match_apex"`
+	// Failure answers DNS queries while the record is blacklisted or inactive, if its action is
+	// empty the global DNSFailure of Settings is used.
+	Failure  Failure  `json:"failure"`
+	Upstream Upstream `json:"upstream"`
+	// QueryTypes limits the DNS query types routed to the record, empty allows every type.
+	QueryTypes []string `json:"query_types"`
+	EDNS       EDNS     `json:"edns"`
+	// RewriteTo is the domain the handler of a dns record expects, it replaces FQDN at the end
+	// of query names before they are forwarded and is replaced by FQDN in the response.
+	RewriteTo string `json:"rewrite_to"`
+}
+
+// RecordView is a record as it is returned by the API, with fields computed when it is returned.
+type RecordView struct {
+	Record
+	// Expired is true if ActiveUntil has passed.
+	Expired bool `json:"expired"`
+}
+
+// IsOwner returns true if user owns the record or is an admin.
+func (r *Record) IsOwner(user *User) bool {
+	return user.IsAdmin() || r.Owner.ID == user.ID
+}
+
+// CanModify returns true if user owns the record, is an admin, or has been granted
+// edit rights through the sharing list.
+func (r *Record) CanModify(user *User) bool {
+	if r.IsOwner(user) {
+		return true
+	}
+	for _, id := range r.SharedWith {
+		if id == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveUserFromShares removes the user id from the sharing list of every record.
+func RemoveUserFromShares(db *boltons.DB, ID string) error {
+	records := []Record{}
+	if err := db.All(&records); err != nil {
+		return err
+	}
+	for i := range records {
+		shared := []string{}
+		for _, id := range records[i].SharedWith {
+			if id != ID {
+				shared = append(shared, id)
+			}
+		}
+		if len(shared) == len(records[i].SharedWith) {
+			continue
+		}
+		if err := db.Update(&records[i], map[string]interface{}{"SharedWith": shared}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // FindRecordsForOwner returns a list of all records for a given owner by their id.
@@ -39,43 +117,88 @@ func FindRecordsForOwner(db *boltons.DB, ID string) ([]Record, error) {
 	return foundRecords, nil
 }
 
-// FindRecordByFQDN returns a single record for the provided fqdn.
+// FindRecordByFQDN returns a single record for the provided fqdn. Names are compared
+// case-insensitively and ignoring a trailing dot.
 func FindRecordByFQDN(db *boltons.DB, fqdn string) (*Record, error) {
 	record := Record{}
 	records := []Record{}
 	if err := db.All(&records); err != nil {
 		return &record, err
 	}
+	fqdn = CanonicalName(fqdn)
 	for _, r := range records {
-		if r.FQDN == fqdn {
+		if CanonicalName(r.FQDN) == fqdn {
 			return &r, nil
 		}
 	}
 	return &record, nil
 }
 
-// FindRecordBySubOfFQDN is used for DNS requests and tries to find
-// the record whos FQDN matches name.
-func FindRecordBySubOfFQDN(db *boltons.DB, name string) (*Record, error) {
+// FindRecordForQuery is used for DNS requests and returns the record that routes name, and
+// whether the query is answered from the static answers of the record rather than proxied to
+// its handler. A record with answers whose FQDN is name always wins. Otherwise the record with
+// the longest FQDN that name is a subdomain of is used, either a dns record or a record with
+// wildcard answers. The FQDN of a dns record itself only matches if MatchApex is set. Names
+// are compared case-insensitively. If zone has an ID, records above it do not route names inside
+// it. If no record matches the returned record has an empty ID.
+func FindRecordForQuery(db *boltons.DB, name string, zone *Zone) (*Record, bool, error) {
 	record := Record{}
 	records := []Record{}
 	if err := db.All(&records); err != nil {
-		return &record, err
+		return &record, false, err
 	}
+	name = CanonicalName(name)
+	static := false
+	best := -1
 	for _, r := range records {
-		if ok, err := regexp.MatchString(".*\\."+regexp.QuoteMeta(r.FQDN)+"\\.", name); ok && err == nil {
-			return &r, nil
+		fqdn := CanonicalName(r.FQDN)
+		if zone.ID != "" && !InZone(fqdn, zone.Name) {
+			continue
+		}
+		score := -1
+		answered := false
+		switch {
+		case fqdn == name && len(r.Answers) > 0:
+			return &r, true, nil
+		case fqdn == name && r.HandlerProtocol == "dns" && r.MatchApex:
+			score = len(fqdn) + 1
+		case strings.HasSuffix(name, "."+fqdn) && r.HandlerProtocol == "dns":
+			score = len(fqdn)
+		case strings.HasSuffix(name, "."+fqdn) && r.Wildcard && len(r.Answers) > 0:
+			score = len(fqdn)
+			answered = true
+		}
+		if score > best {
+			record = r
+			static = answered
+			best = score
 		}
 	}
-	return &record, nil
+	return &record, static, nil
 }
 
 // RecordRequest is used for JSON binding during a request to create a new record.
 type RecordRequest struct {
-	FQDN            string `json:"fqdn"`
-	HandlerHost     string `json:"handler_host"`
-	HandlerPort     int    `json:"handler_port"`
-	HandlerProtocol string `json:"handler_protocol"`
+	FQDN            string   `json:"fqdn"`
+	HandlerHost     string   `json:"handler_host"`
+	HandlerPort     int      `json:"handler_port"`
+	HandlerProtocol string   `json:"handler_protocol"`
+	Capture         bool     `json:"capture"`
+	SharedWith      []string `json:"shared_with"`
+	ProjectID       string   `json:"project_id"`
+	ActiveFrom      int64    `json:"active_from"`
+	ActiveUntil     int64    `json:"active_until"`
+	Windows         []Window `json:"windows"`
+	Timezone        string   `json:"timezone"`
+	Limits          Limits   `json:"limits"`
+	Answers         []Answer `json:"answers"`
+	Wildcard        bool     `json:"wildcard"`
+	MatchApex       bool     `json:"match_apex"`
+	Failure         Failure  `json:"failure"`
+	Upstream        Upstream `json:"upstream"`
+	QueryTypes      []string `json:"query_types"`
+	EDNS            EDNS     `json:"edns"`
+	RewriteTo       string   `json:"rewrite_to"`
 }
 
 // FieldMap implements binding.FieldMap
@@ -85,13 +208,13 @@ func (r *RecordRequest) FieldMap(req *http.Request) binding.FieldMap {
 
 // Validate validates a request payload for a new record.
 func (r *RecordRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
-	if r.FQDN == "" {
+	if !validHostname(CanonicalName(r.FQDN)) {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"fqdn"},
 			Message:    "fqdn must be a valid hostname",
 		})
 	}
-	if ok, err := regexp.Match(`^(?:[0-9]{1,3}\.){3}[0-9]{1,3}$`, []byte(r.HandlerHost)); !ok || err != nil || r.HandlerHost == "" {
+	if ok, err := regexp.Match(`^(?:[0-9]{1,3}\.){3}[0-9]{1,3}$`, []byte(r.HandlerHost)); r.HandlerProtocol != ProtocolStatic && (!ok || err != nil || r.HandlerHost == "") {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"handler_host"},
 			Message:    "handler_host must be a valid IP address",
@@ -103,28 +226,64 @@ func (r *RecordRequest) Validate(req *http.Request, errs binding.Errors) binding
 			Message:    "handler_port must be a valid TCP port",
 		})
 	}
-	if r.HandlerProtocol != "http" && r.HandlerProtocol != "https" && r.HandlerProtocol != "dns" {
+	if r.HandlerProtocol != "http" && r.HandlerProtocol != "https" && r.HandlerProtocol != "dns" && r.HandlerProtocol != ProtocolStatic {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"handler_protocol"},
-			Message:    "handler_protocol must be either http, https, or dns",
+			Message:    "handler_protocol must be either http, https, dns, or static",
 		})
 	}
-	return errs
+	errs = validateLimits(r.Limits, errs)
+	errs = validateAnswers(r.HandlerProtocol, r.Answers, r.Wildcard, r.MatchApex, errs)
+	errs = validateFailure("failure", r.Failure, errs)
+	errs = validateUpstream(r.Upstream, errs)
+	errs = validateQuery(r.QueryTypes, r.EDNS, errs)
+	errs = validateRewrite(r.HandlerProtocol, r.RewriteTo, errs)
+	return validateSchedule(r.ActiveFrom, r.ActiveUntil, r.Timezone, r.Windows, errs)
 }
 
 // UpdateRecordRequest is used to perform JSON binding when updating a record.
 type UpdateRecordRequest struct {
-	FQDN            string `json:"fqdn"`
-	HandlerHost     string `json:"handler_host"`
-	HandlerPort     int    `json:"handler_port"`
-	HandlerProtocol string `json:"handler_protocol"`
-	Blacklist       bool   `json:"blacklist"`
+	FQDN            string   `json:"fqdn"`
+	HandlerHost     string   `json:"handler_host"`
+	HandlerPort     int      `json:"handler_port"`
+	HandlerProtocol string   `json:"handler_protocol"`
+	Blacklist       bool     `json:"blacklist"`
+	Capture         bool     `json:"capture"`
+	SharedWith      []string `json:"shared_with"`
+	ProjectID       string   `json:"project_id"`
+	ActiveFrom      int64    `json:"active_from"`
+	ActiveUntil     int64    `json:"active_until"`
+	Windows         []Window `json:"windows"`
+	Timezone        string   `json:"timezone"`
+	Limits          Limits   `json:"limits"`
+	Answers         []Answer `json:"answers"`
+	Wildcard        bool     `json:"wildcard"`
+	MatchApex       bool     `json:"match_apex"`
+	Failure         Failure  `json:"failure"`
+	Upstream        Upstream `json:"upstream"`
+	QueryTypes      []string `json:"query_types"`
+	EDNS            EDNS     `json:"edns"`
+	RewriteTo       string   `json:"rewrite_to"`
 	Owner           struct {
 		ID    string `json:"id"`
 		Email string `json:"email"`
 	} `json:"owner"`
 }
 
+// UpdateRecordRequestFrom returns the request that updates a record to the state of r, it is used
+// to restore a previous version of a record.
+func UpdateRecordRequestFrom(r *Record) (*UpdateRecordRequest, error) {
+	req := &UpdateRecordRequest{}
+	if err := copier.Copy(req, r); err != nil {
+		return req, err
+	}
+	if req.SharedWith == nil {
+		// Nil would keep the current sharing list rather than restore an empty one.
+		req.SharedWith = []string{}
+	}
+	return req, nil
+}
+
 // FieldMap implements binding.FieldMap
 func (r *UpdateRecordRequest) FieldMap(req *http.Request) binding.FieldMap {
 	return binding.FieldMap{}
@@ -132,13 +291,13 @@ func (r *UpdateRecordRequest) FieldMap(req *http.Request) binding.FieldMap {
 
 // Validate validates a request payload to update a record.
 func (r *UpdateRecordRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
-	if r.FQDN == "" {
+	if !validHostname(CanonicalName(r.FQDN)) {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"fqdn"},
 			Message:    "fqdn must be a valid hostname",
 		})
 	}
-	if r.HandlerHost == "" {
+	if r.HandlerHost == "" && r.HandlerProtocol != ProtocolStatic {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"handler_host"},
 			Message:    "handler_host must be a valid IP address",
@@ -150,10 +309,10 @@ func (r *UpdateRecordRequest) Validate(req *http.Request, errs binding.Errors) b
 			Message:    "handler_port must be a valid TCP port",
 		})
 	}
-	if r.HandlerProtocol != "http" && r.HandlerProtocol != "https" && r.HandlerProtocol != "dns" {
+	if r.HandlerProtocol != "http" && r.HandlerProtocol != "https" && r.HandlerProtocol != "dns" && r.HandlerProtocol != ProtocolStatic {
 		errs = append(errs, binding.Error{
 			FieldNames: []string{"handler_protocol"},
-			Message:    "handler_protocol must be either http, https, or dns",
+			Message:    "handler_protocol must be either http, https, dns, or static",
 		})
 	}
 	if r.Owner.ID == "" {
@@ -168,5 +327,11 @@ func (r *UpdateRecordRequest) Validate(req *http.Request, errs binding.Errors) b
 			Message:    "owner.email is required",
 		})
 	}
-	return errs
+	errs = validateLimits(r.Limits, errs)
+	errs = validateAnswers(r.HandlerProtocol, r.Answers, r.Wildcard, r.MatchApex, errs)
+	errs = validateFailure("failure", r.Failure, errs)
+	errs = validateUpstream(r.Upstream, errs)
+	errs = validateQuery(r.QueryTypes, r.EDNS, errs)
+	errs = validateRewrite(r.HandlerProtocol, r.RewriteTo, errs)
+	return validateSchedule(r.ActiveFrom, r.ActiveUntil, r.Timezone, r.Windows, errs)
 }
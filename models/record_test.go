@@ -0,0 +1,62 @@
+package models
+
+import "testing"
+
+func TestFindRecordForQuery(t *testing.T) {
+	db := newTestDB(t)
+	records := []Record{
+		{ID: "example", FQDN: "example.com", HandlerProtocol: "dns"},
+		{ID: "sub", FQDN: "Sub.Example.com", HandlerProtocol: "dns"},
+		{ID: "apex", FQDN: "apex.example.com", HandlerProtocol: "dns", MatchApex: true},
+		{ID: "deep", FQDN: "a.b.sub.example.com", HandlerProtocol: "dns"},
+		{ID: "static", FQDN: "www.sub.example.com", HandlerProtocol: "dns", Answers: []Answer{{Type: "A", Value: "10.0.0.1"}}},
+		{ID: "wildcard", FQDN: "cdn.example.com", HandlerProtocol: "http", Wildcard: true, Answers: []Answer{{Type: "A", Value: "10.0.0.2"}}},
+		{ID: "exact", FQDN: "static.example.com", HandlerProtocol: "http", Answers: []Answer{{Type: "A", Value: "10.0.0.3"}}},
+		{ID: "http", FQDN: "web.example.com", HandlerProtocol: "http"},
+		{ID: "zoned", FQDN: "ns.zone.example.com", HandlerProtocol: "dns"},
+	}
+	for i := range records {
+		if err := db.Save(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zone := &Zone{ID: "zone", Name: "zone.example.com"}
+	tests := []struct {
+		name   string
+		zone   *Zone
+		id     string
+		static bool
+	}{
+		{"x.example.com.", &Zone{}, "example", false},
+		{"example.com.", &Zone{}, "", false},
+		{"x.sub.example.com.", &Zone{}, "sub", false},
+		{"X.SUB.EXAMPLE.COM.", &Zone{}, "sub", false},
+		{"sub.example.com.", &Zone{}, "example", false},
+		{"b.sub.example.com.", &Zone{}, "sub", false},
+		{"x.a.b.sub.example.com.", &Zone{}, "deep", false},
+		{"apex.example.com.", &Zone{}, "apex", false},
+		{"x.apex.example.com.", &Zone{}, "apex", false},
+		{"www.sub.example.com.", &Zone{}, "static", true},
+		{"x.www.sub.example.com.", &Zone{}, "static", false},
+		{"cdn.example.com.", &Zone{}, "wildcard", true},
+		{"x.cdn.example.com.", &Zone{}, "wildcard", true},
+		{"static.example.com.", &Zone{}, "exact", true},
+		{"x.static.example.com.", &Zone{}, "example", false},
+		{"x.web.example.com.", &Zone{}, "example", false},
+		{"example.org.", &Zone{}, "", false},
+		{"notexample.com.", &Zone{}, "", false},
+		// Records above a zone do not route names inside it.
+		{"x.zone.example.com.", zone, "", false},
+		{"x.ns.zone.example.com.", zone, "zoned", false},
+		{"x.ns.zone.example.com.", &Zone{}, "zoned", false},
+	}
+	for _, tt := range tests {
+		record, static, err := FindRecordForQuery(db, tt.name, tt.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.ID != tt.id || static != tt.static {
+			t.Errorf("FindRecordForQuery(%s, %q) = %q, %v, want %q, %v", tt.name, tt.zone.Name, record.ID, static, tt.id, tt.static)
+		}
+	}
+}
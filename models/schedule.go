@@ -0,0 +1,166 @@
+package models
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mholt/binding"
+)
+
+// days maps the day names accepted in a Window to their time.Weekday.
+var days = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring period of the week during which a record is active. Start and End
+// are times of day formatted as 15:04 in the record's timezone. If End is before Start the
+// window runs past midnight into the next day. An empty list of days means every day.
+type Window struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// minutes parses a time of day formatted as 15:04 and returns the minutes since midnight.
+func minutes(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// onDay returns true if the window applies to day.
+func (w Window) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if wd, ok := days[strings.ToLower(d)]; ok && wd == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns true if t, which must already be in the record's timezone, is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	start, ok := minutes(w.Start)
+	if !ok {
+		return false
+	}
+	end, ok := minutes(w.End)
+	if !ok {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return w.onDay(t.Weekday()) && now >= start && now < end
+	}
+	yesterday := t.AddDate(0, 0, -1).Weekday()
+	return (w.onDay(t.Weekday()) && now >= start) || (w.onDay(yesterday) && now < end)
+}
+
+// locations caches the *time.Location of each timezone name, loading one reads the tzdata files.
+var locations sync.Map
+
+// location returns the timezone of the record's windows, defaulting to UTC. Timezones are
+// validated when a record is saved, one that can no longer be loaded is logged and UTC is used.
+func (r *Record) location() *time.Location {
+	if r.Timezone == "" {
+		return time.UTC
+	}
+	if loc, ok := locations.Load(r.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		log.Printf("could not load timezone %s, using UTC: %v", r.Timezone, err)
+		loc = time.UTC
+	}
+	locations.Store(r.Timezone, loc)
+	return loc
+}
+
+// IsExpired returns true if the record has an end date that has passed.
+func (r *Record) IsExpired(now time.Time) bool {
+	return r.ActiveUntil != 0 && now.Unix() >= r.ActiveUntil
+}
+
+// Active returns true if traffic should be routed for the record at now. A record is active
+// between ActiveFrom and ActiveUntil, when they are set, and during one of its windows, if it
+// has any.
+func (r *Record) Active(now time.Time) bool {
+	if r.ActiveFrom != 0 && now.Unix() < r.ActiveFrom {
+		return false
+	}
+	if r.IsExpired(now) {
+		return false
+	}
+	if len(r.Windows) == 0 {
+		return true
+	}
+	t := now.In(r.location())
+	for _, w := range r.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// View returns the record as it is returned by the API at now.
+func (r *Record) View(now time.Time) *RecordView {
+	return &RecordView{Record: *r, Expired: r.IsExpired(now)}
+}
+
+// validateSchedule appends an error for each invalid scheduling field of a record request.
+func validateSchedule(from, until int64, timezone string, windows []Window, errs binding.Errors) binding.Errors {
+	if from < 0 || until < 0 || (from != 0 && until != 0 && until <= from) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"active_from", "active_until"},
+			Message:    "active_until must be after active_from",
+		})
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"timezone"},
+				Message:    "timezone must be a valid IANA time zone such as America/New_York",
+			})
+		}
+	}
+	for _, w := range windows {
+		start, okStart := minutes(w.Start)
+		end, okEnd := minutes(w.End)
+		if !okStart || !okEnd || start == end {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"windows"},
+				Message:    "windows must have a different start and end formatted as 15:04",
+			})
+			break
+		}
+		valid := true
+		for _, d := range w.Days {
+			if _, ok := days[strings.ToLower(d)]; !ok {
+				valid = false
+			}
+		}
+		if !valid {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"windows"},
+				Message:    "window days must be one of sun, mon, tue, wed, thu, fri, or sat",
+			})
+			break
+		}
+	}
+	return errs
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is a Monday at midnight UTC.
+var monday = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the time days after monday at hh:mm.
+func at(days, hh, mm int) time.Time {
+	return monday.AddDate(0, 0, days).Add(time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute)
+}
+
+func TestWindowContains(t *testing.T) {
+	office := Window{Days: []string{"mon", "TUE"}, Start: "09:00", End: "17:00"}
+	night := Window{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	daily := Window{Start: "23:30", End: "00:30"}
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   bool
+	}{
+		{"office start", office, at(0, 9, 0), true},
+		{"office end", office, at(0, 17, 0), false},
+		{"office before", office, at(1, 8, 59), false},
+		{"office tuesday", office, at(1, 16, 59), true},
+		{"office wednesday", office, at(2, 12, 0), false},
+		{"night friday", night, at(4, 23, 0), true},
+		{"night past midnight", night, at(5, 1, 59), true},
+		{"night end", night, at(5, 2, 0), false},
+		{"night saturday evening", night, at(5, 23, 0), false},
+		{"night thursday past midnight", night, at(4, 1, 0), false},
+		{"daily before midnight", daily, at(6, 23, 45), true},
+		{"daily across week", daily, at(7, 0, 15), true},
+		{"daily noon", daily, at(3, 12, 0), false},
+		{"bad start", Window{Start: "9am", End: "17:00"}, at(0, 12, 0), false},
+		{"bad end", Window{Start: "09:00", End: "25:00"}, at(0, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Contains(tt.t); got != tt.want {
+			t.Errorf("%s: Contains(%v) = %v, want %v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestRecordActive(t *testing.T) {
+	now := at(0, 12, 0)
+	tests := []struct {
+		name   string
+		record Record
+		want   bool
+	}{
+		{"unscheduled", Record{}, true},
+		{"not started", Record{ActiveFrom: now.Unix() + 1}, false},
+		{"started", Record{ActiveFrom: now.Unix()}, true},
+		{"expired", Record{ActiveUntil: now.Unix()}, false},
+		{"not expired", Record{ActiveUntil: now.Unix() + 1}, true},
+		{"in window", Record{Windows: []Window{{Start: "11:00", End: "13:00"}}}, true},
+		{"outside windows", Record{Windows: []Window{{Start: "13:00", End: "14:00"}, {Days: []string{"tue"}, Start: "11:00", End: "13:00"}}}, false},
+		// 12:00 UTC is 07:00 in New York in January.
+		{"timezone in window", Record{Timezone: "America/New_York", Windows: []Window{{Start: "06:00", End: "08:00"}}}, true},
+		{"timezone outside window", Record{Timezone: "America/New_York", Windows: []Window{{Start: "11:00", End: "13:00"}}}, false},
+		// 12:00 UTC on Monday is 01:00 on Tuesday in Auckland.
+		{"timezone weekday", Record{Timezone: "Pacific/Auckland", Windows: []Window{{Days: []string{"tue"}, Start: "00:00", End: "02:00"}}}, true},
+		{"unloadable timezone", Record{Timezone: "Nowhere/Nothing", Windows: []Window{{Start: "11:00", End: "13:00"}}}, true},
+		{"window after expiry", Record{ActiveUntil: now.Unix(), Windows: []Window{{Start: "11:00", End: "13:00"}}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.record.Active(now); got != tt.want {
+			t.Errorf("%s: Active = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecordLocationCached(t *testing.T) {
+	r := &Record{Timezone: "Europe/Berlin"}
+	first := r.location()
+	if first.String() != "Europe/Berlin" {
+		t.Fatalf("location = %s, want Europe/Berlin", first)
+	}
+	if second := r.location(); second != first {
+		t.Error("location loaded the timezone again")
+	}
+	if loc := (&Record{}).location(); loc != time.UTC {
+		t.Errorf("location without a timezone = %s, want UTC", loc)
+	}
+}
+
+func TestRecordIsExpired(t *testing.T) {
+	now := at(0, 12, 0)
+	tests := []struct {
+		until int64
+		want  bool
+	}{
+		{0, false},
+		{now.Unix() - 1, true},
+		{now.Unix(), true},
+		{now.Unix() + 1, false},
+	}
+	for _, tt := range tests {
+		r := &Record{ActiveUntil: tt.until}
+		if got := r.IsExpired(now); got != tt.want {
+			t.Errorf("IsExpired with ActiveUntil %d = %v, want %v", tt.until, got, tt.want)
+		}
+		if view := r.View(now); view.Expired != tt.want || view.ActiveUntil != tt.until {
+			t.Errorf("View with ActiveUntil %d = %+v", tt.until, view)
+		}
+	}
+}
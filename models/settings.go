@@ -0,0 +1,106 @@
+package models
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// settingsID is the id of the single Settings entry stored in the database.
+const settingsID = "global"
+
+// Settings holds application wide options managed by admins through the API.
+type Settings struct {
+	ID          string `json:"-"`
+	RequireTOTP bool   `json:"require_totp"`
+	UpdatedAt   int64  `json:"updated_at"`
+	// Halted is set by the kill switch, no traffic is routed while it is true.
+	Halted   bool   `json:"halted"`
+	HaltedAt int64  `json:"halted_at"`
+	HaltedBy string `json:"halted_by"`
+	// DNSFailure answers DNS queries that no record matches, and those of records without
+	// their own failure action.
+	DNSFailure Failure `json:"dns_failure"`
+}
+
+// settingsMu serializes changes to the stored settings.
+var settingsMu sync.Mutex
+
+// GetSettings returns the stored settings, or the defaults if none have been saved.
+func GetSettings(db *boltons.DB) (*Settings, error) {
+	settings := Settings{ID: settingsID}
+	all := []Settings{}
+	if err := db.All(&all); err != nil {
+		return &settings, err
+	}
+	for _, s := range all {
+		if s.ID == settingsID {
+			return &s, nil
+		}
+	}
+	return &settings, nil
+}
+
+// updateSettings applies change to the stored settings and saves them. Changes are serialized so
+// that concurrent changes to different fields are not lost. The settings from before the change
+// are returned along with the new settings.
+func updateSettings(db *boltons.DB, change func(settings *Settings)) (*Settings, *Settings, error) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	settings, err := GetSettings(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	before := *settings
+	change(settings)
+	if settings.DNSFailure.Answers == nil {
+		settings.DNSFailure.Answers = []Answer{}
+	}
+	settings.UpdatedAt = time.Now().Unix()
+	if err := db.Save(settings); err != nil {
+		return nil, nil, err
+	}
+	return &before, settings, nil
+}
+
+// SetHalted engages or releases the kill switch and saves the settings. by describes who
+// engaged it. The settings from before the change are returned along with the new settings.
+func SetHalted(db *boltons.DB, halted bool, by string) (*Settings, *Settings, error) {
+	return updateSettings(db, func(settings *Settings) {
+		settings.Halted = halted
+		settings.HaltedAt = 0
+		settings.HaltedBy = ""
+		if halted {
+			settings.HaltedAt = time.Now().Unix()
+			settings.HaltedBy = by
+		}
+	})
+}
+
+// UpdateSettings saves the options of req, leaving the kill switch as it is. The settings from
+// before the change are returned along with the new settings.
+func UpdateSettings(db *boltons.DB, req *SettingsRequest) (*Settings, *Settings, error) {
+	return updateSettings(db, func(settings *Settings) {
+		settings.RequireTOTP = req.RequireTOTP
+		settings.DNSFailure = req.DNSFailure
+	})
+}
+
+// SettingsRequest is used for JSON binding when updating settings.
+type SettingsRequest struct {
+	RequireTOTP bool    `json:"require_totp"`
+	DNSFailure  Failure `json:"dns_failure"`
+}
+
+// FieldMap implements binding.FieldMap
+func (s *SettingsRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload to update settings.
+func (s *SettingsRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	return validateFailure("dns_failure", s.DNSFailure, errs)
+}
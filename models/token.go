@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// RevokedToken is the id of a JWT that must no longer be accepted. It is kept until the
+// token would have expired anyway.
+type RevokedToken struct {
+	ID        string `json:"id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RevokeToken stores the token id so that it is rejected until it expires. Previously revoked
+// tokens that have since expired are removed.
+func RevokeToken(db *boltons.DB, ID string, expiresAt int64) error {
+	revoked := []RevokedToken{}
+	if err := db.All(&revoked); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for i := range revoked {
+		if revoked[i].ExpiresAt < now {
+			if err := db.Delete(&revoked[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return db.Save(&RevokedToken{ID: ID, ExpiresAt: expiresAt})
+}
+
+// IsTokenRevoked returns true if the token id has been revoked.
+func IsTokenRevoked(db *boltons.DB, ID string) (bool, error) {
+	keys, err := db.Keys(RevokedToken{})
+	if err != nil {
+		return true, err
+	}
+	for _, k := range keys {
+		if k == ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateTokenClaims checks the claims of a parsed token against the database and returns the
+// user the token was issued to. A token is rejected if its user no longer exists, its version
+// does not match the user's current token version, or it has been revoked.
+func ValidateTokenClaims(db *boltons.DB, claims map[string]interface{}) (*User, error) {
+	id, _ := claims["id"].(string)
+	jti, _ := claims["jti"].(string)
+	ver, ok := claims["ver"].(float64)
+	if id == "" || jti == "" || !ok {
+		return nil, errors.New("token is missing required claims")
+	}
+	user := &User{ID: id}
+	if ok, err := db.Exists(user); err != nil || !ok {
+		return nil, errors.New("user does not exist")
+	}
+	if err := db.Get(user); err != nil {
+		return nil, err
+	}
+	if int(ver) != user.TokenVersion {
+		return nil, errors.New("token has been invalidated")
+	}
+	if revoked, err := IsTokenRevoked(db, jti); err != nil || revoked {
+		return nil, errors.New("token has been revoked")
+	}
+	return user, nil
+}
+
+// RefreshTokenRequest is used for JSON binding when exchanging a refresh token for a new access token,
+// and optionally when logging out.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// FieldMap implements binding.FieldMap
+func (r *RefreshTokenRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload to refresh a token.
+func (r *RefreshTokenRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if r.RefreshToken == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"refresh_token"},
+			Message:    "refresh_token is required",
+		})
+	}
+	return errs
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nlf/boltons"
+)
+
+// newTestDB returns an empty database in a temporary directory that is removed when the test ends.
+func newTestDB(t *testing.T) *boltons.DB {
+	dir, err := ioutil.TempDir("", "shellsquid-models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := boltons.Open(filepath.Join(dir, "squid.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRevokeToken(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().Unix()
+	if err := RevokeToken(db, "expired", now-10); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeToken(db, "live", now+3600); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking another token removes the ones that have since expired.
+	if err := RevokeToken(db, "other", now+3600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"expired", false},
+		{"live", true},
+		{"other", true},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		revoked, err := IsTokenRevoked(db, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != tt.want {
+			t.Errorf("IsTokenRevoked(%s) = %v, want %v", tt.id, revoked, tt.want)
+		}
+	}
+}
+
+func TestValidateTokenClaims(t *testing.T) {
+	db := newTestDB(t)
+	user := &User{ID: "user-1", Email: "alice@example.org", TokenVersion: 2}
+	if err := db.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeToken(db, "revoked", time.Now().Unix()+3600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{"valid", map[string]interface{}{"id": "user-1", "jti": "a", "ver": float64(2)}, true},
+		{"old version", map[string]interface{}{"id": "user-1", "jti": "a", "ver": float64(1)}, false},
+		{"revoked", map[string]interface{}{"id": "user-1", "jti": "revoked", "ver": float64(2)}, false},
+		{"unknown user", map[string]interface{}{"id": "user-2", "jti": "a", "ver": float64(0)}, false},
+		{"missing id", map[string]interface{}{"jti": "a", "ver": float64(2)}, false},
+		{"missing jti", map[string]interface{}{"id": "user-1", "ver": float64(2)}, false},
+		{"missing version", map[string]interface{}{"id": "user-1", "jti": "a"}, false},
+	}
+	for _, tt := range tests {
+		got, err := ValidateTokenClaims(db, tt.claims)
+		if tt.valid && (err != nil || got.ID != user.ID) {
+			t.Errorf("%s: ValidateTokenClaims = %v, %v, want user-1", tt.name, got, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: ValidateTokenClaims accepted the claims", tt.name)
+		}
+	}
+}
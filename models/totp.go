@@ -0,0 +1,95 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmcvetta/randutil"
+	"github.com/mholt/binding"
+	"github.com/tomsteele/shellsquid/totp"
+)
+
+// recoveryCodeCount is the number of recovery codes generated when a user enrolls.
+const recoveryCodeCount = 10
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes and returns the new codes.
+// Only hashes of the codes are kept on the user.
+func (u *User) GenerateRecoveryCodes() ([]string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randutil.String(10, "abcdefghjkmnpqrstuvwxyz23456789")
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	u.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// VerifyTOTP checks code against the user's TOTP secret. A code is accepted at most once, on
+// success the user's last used counter is advanced and must be saved by the caller.
+func (u *User) VerifyTOTP(code string) bool {
+	if u.TOTPSecret == "" {
+		return false
+	}
+	counter, ok := totp.Validate(u.TOTPSecret, code, time.Now(), 1)
+	if !ok || counter <= u.TOTPLastCounter {
+		return false
+	}
+	u.TOTPLastCounter = counter
+	return true
+}
+
+// UseRecoveryCode checks code against the user's recovery codes. A matching code is removed
+// from the user and the caller must save the user.
+func (u *User) UseRecoveryCode(code string) bool {
+	hash := []byte(hashRecoveryCode(code))
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), hash) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DisableTOTP removes the user's TOTP enrollment.
+func (u *User) DisableTOTP() {
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.TOTPLastCounter = 0
+	u.RecoveryCodes = []string{}
+}
+
+// TOTPRequest is used for JSON binding when verifying or removing a TOTP enrollment.
+type TOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// FieldMap implements binding.FieldMap
+func (t *TOTPRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload containing a TOTP code.
+func (t *TOTPRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if t.Code == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"code"},
+			Message:    "code is required",
+		})
+	}
+	return errs
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/mholt/binding"
+)
+
+// DefaultUpstreamTimeout is the time, in milliseconds, to wait for a DNS handler to respond if a
+// record does not set one.
+const DefaultUpstreamTimeout = 2000
+
+// Upstream controls how DNS queries are sent to the handler of a record. Timeout is in
+// milliseconds and applies to each attempt. Every attempt tries the handler followed by each
+// of the Fallbacks, formatted as host:port, and the query fails after Retries further
+// attempts, which back off from 50 milliseconds. A response truncated over UDP is retried over
+// TCP.
+type Upstream struct {
+	Timeout   int      `json:"timeout"`
+	Retries   int      `json:"retries"`
+	Fallbacks []string `json:"fallbacks"`
+}
+
+// UpstreamTimeout returns the timeout of each attempt in milliseconds, or the default.
+func (u Upstream) UpstreamTimeout() int {
+	if u.Timeout <= 0 {
+		return DefaultUpstreamTimeout
+	}
+	return u.Timeout
+}
+
+// validateUpstream appends an error for each invalid upstream option of a record request.
+func validateUpstream(u Upstream, errs binding.Errors) binding.Errors {
+	if u.Timeout < 0 || u.Timeout > 30000 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"upstream.timeout"},
+			Message:    "upstream.timeout must be between 0 and 30000 milliseconds",
+		})
+	}
+	if u.Retries < 0 || u.Retries > 5 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"upstream.retries"},
+			Message:    "upstream.retries must be between 0 and 5",
+		})
+	}
+	for _, addr := range u.Fallbacks {
+		host, port, err := net.SplitHostPort(addr)
+		p, perr := strconv.Atoi(port)
+		if err != nil || net.ParseIP(host) == nil || perr != nil || p < 1 || p > 65535 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"upstream.fallbacks"},
+				Message:    "upstream.fallbacks must be addresses such as 10.0.0.2:53",
+			})
+			break
+		}
+	}
+	return errs
+}
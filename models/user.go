@@ -9,20 +9,118 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Sources a user may be created by.
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+	SourceOIDC  = "oidc"
+)
+
+// Roles a user may hold. Each role includes the permissions of the roles below it.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole returns true if role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// RoleAtLeast returns true if role grants at least the permissions of required.
+func RoleAtLeast(role, required string) bool {
+	return ValidRole(role) && roleLevels[role] >= roleLevels[required]
+}
+
+// HighestRole returns the role among roles that grants the most permissions, or an empty
+// string if none of them are valid.
+func HighestRole(roles []string) string {
+	highest := ""
+	for _, role := range roles {
+		if ValidRole(role) && roleLevels[role] > roleLevels[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
 // User is a single user of the application.
 type User struct {
-	ID        string `json:"id"`
-	Hash      string `json:"hash"`
-	Email     string `json:"email"`
+	ID    string `json:"id"`
+	Hash  string `json:"hash"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// Source is the authenticator that created the user, users from external sources
+	// do not have a local password.
+	Source    string `json:"source"`
 	CreatedAt int64  `json:"created_at"`
 	UpdatedAt int64  `json:"updated_at"`
+	// TokenVersion is embedded in every token issued to the user. Incrementing it
+	// invalidates all of the user's outstanding tokens.
+	TokenVersion int `json:"token_version"`
+	// TOTPSecret is set when enrollment starts, TOTPEnabled once the first code is verified.
+	TOTPSecret      string   `json:"-"`
+	TOTPEnabled     bool     `json:"totp_enabled"`
+	TOTPLastCounter int64    `json:"-"`
+	RecoveryCodes   []string `json:"-"`
+	// FailedLogins counts consecutive failed logins, LockedUntil is set once it reaches the lockout threshold.
+	FailedLogins    int   `json:"failed_logins"`
+	LastFailedLogin int64 `json:"last_failed_login"`
+	LockedUntil     int64 `json:"locked_until"`
+}
+
+// IsAdmin returns true if the user holds the admin role.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Locked returns true if the user's account is locked at the unix time now.
+func (u *User) Locked(now int64) bool {
+	return u.LockedUntil > now
+}
+
+// LoginFailureWindow is how long, in seconds, a failed login counts towards the lockout of an
+// account.
+const LoginFailureWindow = 24 * 60 * 60
+
+// RecordLoginFailure counts a failed login at the unix time now. Once threshold consecutive
+// failures are reached the account is locked for lockout seconds and the count starts over.
+// Failures older than LoginFailureWindow are no longer counted.
+func (u *User) RecordLoginFailure(now int64, threshold int, lockout int64) {
+	if now-u.LastFailedLogin > LoginFailureWindow {
+		u.FailedLogins = 0
+	}
+	u.FailedLogins++
+	u.LastFailedLogin = now
+	if threshold > 0 && u.FailedLogins >= threshold {
+		u.LockedUntil = now + lockout
+		u.FailedLogins = 0
+	}
+}
+
+// ResetLoginFailures clears failed logins and any lockout.
+func (u *User) ResetLoginFailures() {
+	u.FailedLogins = 0
+	u.LastFailedLogin = 0
+	u.LockedUntil = 0
 }
 
 // NewUser creates and returns a new user object provided a username and password.
+// The user is given the viewer role.
 func NewUser(email string, password []byte) (*User, error) {
 	now := time.Now().Unix()
 	user := &User{
 		Email:     email,
+		Role:      RoleViewer,
+		Source:    SourceLocal,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
@@ -49,10 +147,26 @@ func FindUserByEmail(db *boltons.DB, email string) (*User, error) {
 	return &user, nil
 }
 
+// CountAdmins returns the number of users holding the admin role.
+func CountAdmins(db *boltons.DB) (int, error) {
+	users := []User{}
+	if err := db.All(&users); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, u := range users {
+		if u.IsAdmin() {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // UserRequest is used for JSON binding when creating a new user.
 type UserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Role     string `json:"role"`
 }
 
 // FieldMap implements binding.FieldMap
@@ -74,6 +188,12 @@ func (u *UserRequest) Validate(req *http.Request, errs binding.Errors) binding.E
 			Message:    "password is required",
 		})
 	}
+	if u.Role != "" && !ValidRole(u.Role) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"role"},
+			Message:    "role must be either admin, operator, or viewer",
+		})
+	}
 	return errs
 }
 
@@ -81,6 +201,7 @@ func (u *UserRequest) Validate(req *http.Request, errs binding.Errors) binding.E
 type UserTokenRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Code     string `json:"code"`
 }
 
 // FieldMap implements binding.FieldMap
@@ -108,6 +229,7 @@ func (u *UserTokenRequest) Validate(req *http.Request, errs binding.Errors) bind
 // UserUpdateRequest is used to perform JSON binding when updating a user.
 type UserUpdateRequest struct {
 	Password string `json:"password"`
+	Role     string `json:"role"`
 }
 
 // FieldMap implements binding.FieldMap
@@ -117,10 +239,16 @@ func (u *UserUpdateRequest) FieldMap(req *http.Request) binding.FieldMap {
 
 // Validate validates a request payload to update a user.
 func (u *UserUpdateRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
-	if u.Password == "" {
+	if u.Password == "" && u.Role == "" {
 		errs = append(errs, binding.Error{
-			FieldNames: []string{"password"},
-			Message:    "password is required",
+			FieldNames: []string{"password", "role"},
+			Message:    "password or role is required",
+		})
+	}
+	if u.Role != "" && !ValidRole(u.Role) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"role"},
+			Message:    "role must be either admin, operator, or viewer",
 		})
 	}
 	return errs
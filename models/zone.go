@@ -0,0 +1,198 @@
+package models
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/binding"
+	"github.com/nlf/boltons"
+)
+
+// Default SOA timers, in seconds, used when a zone does not set them.
+const (
+	DefaultZoneTTL     = 3600
+	DefaultZoneRefresh = 7200
+	DefaultZoneRetry   = 3600
+	DefaultZoneExpire  = 1209600
+	DefaultZoneMinimum = 300
+)
+
+var hostnameRegexp = regexp.MustCompile(`^([a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// CanonicalName lowercases name and removes any trailing dot.
+func CanonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// validHostname returns true if name is a valid canonical hostname.
+func validHostname(name string) bool {
+	return len(name) <= 253 && hostnameRegexp.MatchString(name)
+}
+
+// InZone returns true if name is apex or a subdomain of it. Both must be canonical.
+func InZone(name, apex string) bool {
+	return name == apex || strings.HasSuffix(name, "."+apex)
+}
+
+// NameServer is a name server for a zone. Addresses are the glue records answered for name
+// servers inside the zone.
+type NameServer struct {
+	Name string   `json:"name"`
+	A    []string `json:"a"`
+	AAAA []string `json:"aaaa"`
+}
+
+// Zone is a domain that shellsquid answers authoritatively. The apex SOA and NS records and
+// the addresses of in-zone name servers are answered directly, names matching a dns record are
+// proxied to its handler, and every other name in the zone does not exist.
+type Zone struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	NameServers []NameServer `json:"name_servers"`
+	Mbox        string       `json:"mbox"`
+	Serial      uint32       `json:"serial"`
+	TTL         uint32       `json:"ttl"`
+	Refresh     uint32       `json:"refresh"`
+	Retry       uint32       `json:"retry"`
+	Expire      uint32       `json:"expire"`
+	Minimum     uint32       `json:"minimum"`
+	CreatedAt   int64        `json:"created_at"`
+	UpdatedAt   int64        `json:"updated_at"`
+}
+
+// NameServer returns the name server of the zone called name. If there is none the returned
+// name server has an empty Name.
+func (z *Zone) NameServer(name string) NameServer {
+	for _, ns := range z.NameServers {
+		if CanonicalName(ns.Name) == name {
+			return ns
+		}
+	}
+	return NameServer{}
+}
+
+// FindZoneByName returns the zone with the provided name.
+func FindZoneByName(db *boltons.DB, name string) (*Zone, error) {
+	zone := Zone{}
+	zones := []Zone{}
+	if err := db.All(&zones); err != nil {
+		return &zone, err
+	}
+	for _, z := range zones {
+		if z.Name == name {
+			return &z, nil
+		}
+	}
+	return &zone, nil
+}
+
+// FindZoneByID returns a single zone for the provided id.
+func FindZoneByID(db *boltons.DB, ID string) (*Zone, error) {
+	zone := Zone{}
+	zones := []Zone{}
+	if err := db.All(&zones); err != nil {
+		return &zone, err
+	}
+	for _, z := range zones {
+		if z.ID == ID {
+			return &z, nil
+		}
+	}
+	return &zone, nil
+}
+
+// FindZoneForName returns the most specific zone that name, a DNS query name, belongs to. If
+// there is none the returned zone has an empty ID.
+func FindZoneForName(db *boltons.DB, name string) (*Zone, error) {
+	zone := Zone{}
+	zones := []Zone{}
+	if err := db.All(&zones); err != nil {
+		return &zone, err
+	}
+	name = CanonicalName(name)
+	for _, z := range zones {
+		if InZone(name, z.Name) && len(z.Name) > len(zone.Name) {
+			zone = z
+		}
+	}
+	return &zone, nil
+}
+
+// ZoneRequest is used for JSON binding when creating or updating a zone. Zero SOA timers use
+// the defaults.
+type ZoneRequest struct {
+	Name        string       `json:"name"`
+	NameServers []NameServer `json:"name_servers"`
+	Mbox        string       `json:"mbox"`
+	TTL         uint32       `json:"ttl"`
+	Refresh     uint32       `json:"refresh"`
+	Retry       uint32       `json:"retry"`
+	Expire      uint32       `json:"expire"`
+	Minimum     uint32       `json:"minimum"`
+}
+
+// FieldMap implements binding.FieldMap
+func (z *ZoneRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{}
+}
+
+// Validate validates a request payload for a zone.
+func (z *ZoneRequest) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	name := CanonicalName(z.Name)
+	if !validHostname(name) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"name"},
+			Message:    "name must be a valid domain name",
+		})
+	}
+	if z.Mbox != "" && !validHostname(CanonicalName(z.Mbox)) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"mbox"},
+			Message:    "mbox must be a domain name such as hostmaster.example.com",
+		})
+	}
+	if len(z.NameServers) == 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"name_servers"},
+			Message:    "at least one name server is required",
+		})
+	}
+	for _, ns := range z.NameServers {
+		nsName := CanonicalName(ns.Name)
+		if !validHostname(nsName) {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"name_servers"},
+				Message:    "name server names must be valid hostnames",
+			})
+			break
+		}
+		valid := true
+		for _, a := range ns.A {
+			if ip := net.ParseIP(a); ip == nil || ip.To4() == nil {
+				valid = false
+			}
+		}
+		for _, a := range ns.AAAA {
+			if ip := net.ParseIP(a); ip == nil || ip.To4() != nil {
+				valid = false
+			}
+		}
+		if !valid {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"name_servers"},
+				Message:    "name server a must be IPv4 and aaaa IPv6 addresses",
+			})
+			break
+		}
+		if InZone(nsName, name) && len(ns.A)+len(ns.AAAA) == 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"name_servers"},
+				Message:    "name servers inside the zone require an a or aaaa address",
+			})
+			break
+		}
+	}
+	return errs
+}
@@ -0,0 +1,333 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	magic       = 0xa1b2c3d4
+	snapLen     = 65535
+	linkTypeRaw = 101
+	protoTCP    = 6
+	protoUDP    = 17
+)
+
+const (
+	headerLen = 24
+	// flowTimeout is how long the sequence numbers of an idle TCP flow are kept.
+	flowTimeout = 10 * time.Minute
+)
+
+// Store writes synthesized packets into one pcap file per record. When a file would grow past
+// the maximum size it is moved aside, keeping a single previous file per record.
+type Store struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+	flows   map[string]*flow
+}
+
+// flow is the next sequence number of one direction of a TCP connection.
+type flow struct {
+	seq  uint32
+	used time.Time
+}
+
+// NewStore returns a Store that keeps capture files in dir, creating it if needed. Files are
+// rotated once they reach maxSize bytes, a maxSize of 0 disables rotation.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, maxSize: maxSize, flows: map[string]*flow{}}, nil
+}
+
+// Path returns the location of the capture file for the record id.
+func (s *Store) Path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".pcap")
+}
+
+// rotatedPath returns the location of the previous capture file for the record id.
+func (s *Store) rotatedPath(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".1.pcap")
+}
+
+// Read returns the capture for the record id, the packets of the previous file followed by
+// those of the current one.
+func (s *Store) Read(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := ioutil.ReadFile(s.Path(id))
+	if err != nil {
+		return nil, err
+	}
+	previous, err := ioutil.ReadFile(s.rotatedPath(id))
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > headerLen {
+		previous = append(previous, data[headerLen:]...)
+	}
+	return previous, nil
+}
+
+// Remove deletes the capture files for the record id.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.flows {
+		if strings.HasPrefix(key, id+"|") {
+			delete(s.flows, key)
+		}
+	}
+	for _, path := range []string{s.Path(id), s.rotatedPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// next returns the sequence and acknowledgment numbers for a TCP segment of n bytes sent from
+// src to dst and advances the sequence number of that direction. Every direction of a
+// connection starts at 1.
+func (s *Store) next(id string, src, dst net.Addr, n int, now time.Time) (uint32, uint32) {
+	key := id + "|" + src.String() + "|" + dst.String()
+	sent, ok := s.flows[key]
+	if !ok {
+		if len(s.flows) > 0 && len(s.flows)%1024 == 0 {
+			s.expireFlows(now)
+		}
+		sent = &flow{seq: 1}
+		s.flows[key] = sent
+	}
+	ack := uint32(1)
+	if received, ok := s.flows[id+"|"+dst.String()+"|"+src.String()]; ok {
+		ack = received.seq
+	}
+	seq := sent.seq
+	sent.seq += uint32(n)
+	sent.used = now
+	return seq, ack
+}
+
+// expireFlows forgets the sequence numbers of flows idle for longer than flowTimeout.
+func (s *Store) expireFlows(now time.Time) {
+	for key, f := range s.flows {
+		if now.Sub(f.used) > flowTimeout {
+			delete(s.flows, key)
+		}
+	}
+}
+
+// open returns the capture file for the record id ready to append a packet of n bytes,
+// rotating it first if the packet would take it past the maximum size.
+func (s *Store) open(id string, n int) (*os.File, error) {
+	path := s.Path(id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	if s.maxSize > 0 && size > headerLen && size+16+int64(n) > s.maxSize {
+		f.Close()
+		if err := os.Rename(path, s.rotatedPath(id)); err != nil {
+			return nil, err
+		}
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return nil, err
+		}
+		size = 0
+	}
+	if size == 0 {
+		header := make([]byte, headerLen)
+		binary.LittleEndian.PutUint32(header[0:], magic)
+		binary.LittleEndian.PutUint16(header[4:], 2)
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], snapLen)
+		binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+		if _, err := f.Write(header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Write appends payload to the capture file for the record id as a single packet
+// sent from src to dst. The transport is taken from the type of src, TCP payloads
+// are prefixed with their two byte length as DNS over TCP requires and numbered
+// following the previous payloads between the same addresses.
+func (s *Store) Write(id string, src, dst net.Addr, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var seq, ack uint32
+	if _, ok := src.(*net.TCPAddr); ok {
+		seq, ack = s.next(id, src, dst, len(payload)+2, now)
+	}
+	packet, err := frame(src, dst, payload, seq, ack)
+	if err != nil {
+		return err
+	}
+	f, err := s.open(id, len(packet))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	if _, err := f.Write(record); err != nil {
+		return err
+	}
+	_, err = f.Write(packet)
+	return err
+}
+
+// frame builds a raw IP packet carrying payload between src and dst.
+func frame(src, dst net.Addr, payload []byte, seq, ack uint32) ([]byte, error) {
+	var (
+		srcIP, dstIP     net.IP
+		srcPort, dstPort int
+		proto            byte
+		segment          []byte
+	)
+	switch a := src.(type) {
+	case *net.UDPAddr:
+		b, ok := dst.(*net.UDPAddr)
+		if !ok {
+			return nil, errors.New("pcap: mismatched address types")
+		}
+		srcIP, srcPort, dstIP, dstPort = a.IP, a.Port, b.IP, b.Port
+		proto = protoUDP
+		segment = make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+		binary.BigEndian.PutUint16(segment[2:], uint16(dstPort))
+		binary.BigEndian.PutUint16(segment[4:], uint16(len(segment)))
+		copy(segment[8:], payload)
+	case *net.TCPAddr:
+		b, ok := dst.(*net.TCPAddr)
+		if !ok {
+			return nil, errors.New("pcap: mismatched address types")
+		}
+		srcIP, srcPort, dstIP, dstPort = a.IP, a.Port, b.IP, b.Port
+		proto = protoTCP
+		segment = make([]byte, 22+len(payload))
+		binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+		binary.BigEndian.PutUint16(segment[2:], uint16(dstPort))
+		binary.BigEndian.PutUint32(segment[4:], seq)
+		binary.BigEndian.PutUint32(segment[8:], ack)
+		segment[12] = 5 << 4
+		segment[13] = 0x18 // PSH, ACK
+		binary.BigEndian.PutUint16(segment[14:], 65535)
+		binary.BigEndian.PutUint16(segment[20:], uint16(len(payload)))
+		copy(segment[22:], payload)
+	default:
+		return nil, errors.New("pcap: unsupported address type")
+	}
+
+	srcIP, dstIP = normalize(srcIP, dstIP)
+	if v4 := srcIP.To4(); v4 != nil {
+		packet := make([]byte, 20+len(segment))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+		binary.BigEndian.PutUint16(packet[6:], 0x4000)
+		packet[8] = 64
+		packet[9] = proto
+		copy(packet[12:16], v4)
+		copy(packet[16:20], dstIP.To4())
+		binary.BigEndian.PutUint16(packet[10:], checksum(packet[:20], 0))
+		putTransportChecksum(segment, proto, packet[12:16], packet[16:20])
+		copy(packet[20:], segment)
+		return packet, nil
+	}
+	packet := make([]byte, 40+len(segment))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(segment)))
+	packet[6] = proto
+	packet[7] = 64
+	copy(packet[8:24], srcIP.To16())
+	copy(packet[24:40], dstIP.To16())
+	putTransportChecksum(segment, proto, packet[8:24], packet[24:40])
+	copy(packet[40:], segment)
+	return packet, nil
+}
+
+// normalize makes both addresses the same family. Listeners bound to all
+// interfaces report an unspecified local address which is mapped to the
+// family of the peer.
+func normalize(a, b net.IP) (net.IP, net.IP) {
+	if a == nil || a.IsUnspecified() {
+		if b.To4() != nil {
+			a = net.IPv4zero
+		} else {
+			a = net.IPv6unspecified
+		}
+	}
+	if b == nil || b.IsUnspecified() {
+		if a.To4() != nil {
+			b = net.IPv4zero
+		} else {
+			b = net.IPv6unspecified
+		}
+	}
+	if a.To4() != nil && b.To4() != nil {
+		return a.To4(), b.To4()
+	}
+	return a.To16(), b.To16()
+}
+
+// putTransportChecksum sets the UDP or TCP checksum of segment using the
+// pseudo header built from src and dst.
+func putTransportChecksum(segment []byte, proto byte, src, dst net.IP) {
+	var sum uint32
+	for _, ip := range [][]byte{src, dst} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(ip[i])<<8 | uint32(ip[i+1])
+		}
+	}
+	sum += uint32(proto)
+	sum += uint32(len(segment))
+	offset := 6
+	if proto == protoTCP {
+		offset = 16
+	}
+	csum := checksum(segment, sum)
+	if proto == protoUDP && csum == 0 {
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:], csum)
+}
+
+// checksum computes the internet checksum of b starting from the partial sum.
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,213 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func newTestStore(t *testing.T, maxSize int64) *Store {
+	dir, err := ioutil.TempDir("", "shellsquid-pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := NewStore(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// packet is one record read back from a capture file.
+type packet struct {
+	data []byte
+}
+
+// readPackets parses a capture file, checking its header.
+func readPackets(t *testing.T, data []byte) []packet {
+	if len(data) < headerLen {
+		t.Fatalf("capture of %d bytes has no header", len(data))
+	}
+	if binary.LittleEndian.Uint32(data) != magic {
+		t.Fatalf("bad magic %x", binary.LittleEndian.Uint32(data))
+	}
+	if link := binary.LittleEndian.Uint32(data[20:]); link != linkTypeRaw {
+		t.Fatalf("link type = %d, want %d", link, linkTypeRaw)
+	}
+	var packets []packet
+	for data = data[headerLen:]; len(data) > 0; {
+		if len(data) < 16 {
+			t.Fatalf("truncated record header")
+		}
+		n := int(binary.LittleEndian.Uint32(data[8:]))
+		if len(data) < 16+n {
+			t.Fatalf("truncated record of %d bytes", n)
+		}
+		packets = append(packets, packet{data[16 : 16+n]})
+		data = data[16+n:]
+	}
+	return packets
+}
+
+// tcpNumbers returns the sequence and acknowledgment numbers of an IPv4 TCP packet.
+func tcpNumbers(t *testing.T, p packet) (uint32, uint32) {
+	if p.data[9] != protoTCP {
+		t.Fatalf("protocol = %d, want TCP", p.data[9])
+	}
+	segment := p.data[20:]
+	return binary.BigEndian.Uint32(segment[4:]), binary.BigEndian.Uint32(segment[8:])
+}
+
+func TestTCPSequencePerFlow(t *testing.T) {
+	s := newTestStore(t, 0)
+	client := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+	server := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}
+	other := &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 40001}
+
+	writes := []struct {
+		id       string
+		src, dst net.Addr
+		payload  int
+		seq, ack uint32
+	}{
+		{"a", client, server, 30, 1, 1},
+		{"a", server, client, 100, 1, 33},
+		{"a", client, server, 10, 33, 103},
+		// A different connection and a different record start their own flows.
+		{"a", other, server, 5, 1, 1},
+		{"b", client, server, 7, 1, 1},
+		{"a", server, client, 20, 103, 45},
+	}
+	for _, w := range writes {
+		if err := s.Write(w.id, w.src, w.dst, make([]byte, w.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []packet
+	for _, id := range []string{"a", "b"} {
+		data, err := s.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, readPackets(t, data)...)
+	}
+	// Packets of record b come after those of record a.
+	order := []int{0, 1, 2, 3, 5, 4}
+	if len(got) != len(order) {
+		t.Fatalf("read %d packets, want %d", len(got), len(order))
+	}
+	for i, j := range order {
+		w := writes[j]
+		seq, ack := tcpNumbers(t, got[i])
+		if seq != w.seq || ack != w.ack {
+			t.Errorf("write %d: seq, ack = %d, %d, want %d, %d", j, seq, ack, w.seq, w.ack)
+		}
+	}
+}
+
+func TestRemoveResetsFlows(t *testing.T) {
+	s := newTestStore(t, 0)
+	client := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+	server := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}
+	if err := s.Write("a", client, server, make([]byte, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read("a"); !os.IsNotExist(err) {
+		t.Fatalf("Read after Remove = %v, want not exist", err)
+	}
+	if err := s.Write("a", client, server, make([]byte, 30)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := readPackets(t, data)
+	if len(packets) != 1 {
+		t.Fatalf("read %d packets, want 1", len(packets))
+	}
+	if seq, _ := tcpNumbers(t, packets[0]); seq != 1 {
+		t.Errorf("seq after Remove = %d, want 1", seq)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}
+	// Each packet is 16 bytes of record header, 28 bytes of IPv4 and UDP headers and the payload.
+	const payload = 56
+	const record = 16 + 28 + payload
+	s := newTestStore(t, headerLen+2*record)
+
+	for i := 0; i < 5; i++ {
+		p := make([]byte, payload)
+		p[0] = byte(i)
+		if err := s.Write("a", client, server, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{s.Path("a"), s.rotatedPath("a")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > headerLen+2*record {
+			t.Errorf("%s is %d bytes, over the maximum", path, info.Size())
+		}
+	}
+	data, err := s.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := readPackets(t, data)
+	// The oldest two packets were dropped with the first rotated file.
+	want := []byte{2, 3, 4}
+	if len(packets) != len(want) {
+		t.Fatalf("read %d packets, want %d", len(packets), len(want))
+	}
+	for i, p := range packets {
+		if first := p.data[28]; first != want[i] {
+			t.Errorf("packet %d carries payload %d, want %d", i, first, want[i])
+		}
+	}
+}
+
+func TestFrameChecksums(t *testing.T) {
+	tests := []struct {
+		src, dst net.Addr
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}, &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}, &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}},
+		{&net.UDPAddr{IP: net.IPv6zero, Port: 53}, &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}},
+	}
+	for _, tt := range tests {
+		packet, err := frame(tt.src, tt.dst, []byte("payload"), 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet[0]>>4 != 4 {
+			t.Fatalf("%v: IP version = %d, want 4", tt.src, packet[0]>>4)
+		}
+		if sum := checksum(packet[:20], 0); sum != 0 {
+			t.Errorf("%v: IP header checksum does not verify", tt.src)
+		}
+		segment := packet[20:]
+		var pseudo uint32
+		for i := 12; i < 20; i += 2 {
+			pseudo += uint32(packet[i])<<8 | uint32(packet[i+1])
+		}
+		pseudo += uint32(packet[9]) + uint32(len(segment))
+		if sum := checksum(segment, pseudo); sum != 0 {
+			t.Errorf("%v: transport checksum does not verify", tt.src)
+		}
+	}
+	if _, err := frame(&net.UDPAddr{}, &net.TCPAddr{}, nil, 0, 0); err == nil {
+		t.Error("frame accepted mismatched address types")
+	}
+}
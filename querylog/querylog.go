@@ -0,0 +1,264 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single DNS query handled by the proxy. Rcode is empty if no response was sent.
+type Entry struct {
+	Time       int64   `json:"time"`
+	Resolver   string  `json:"resolver"`
+	QName      string  `json:"qname"`
+	QType      string  `json:"qtype"`
+	RecordID   string  `json:"record_id"`
+	RecordFQDN string  `json:"record_fqdn"`
+	Rcode      string  `json:"rcode"`
+	LatencyMS  float64 `json:"latency_ms"`
+	timeNano   int64
+}
+
+// Filter selects entries. Empty fields match every entry, QName matches the name itself and its
+// subdomains and Since and Until are unix timestamps. If Records is not nil only entries for the
+// record ids set in it match, so entries that did not match a record are excluded.
+type Filter struct {
+	Records  map[string]bool
+	RecordID string
+	Resolver string
+	QName    string
+	QType    string
+	Rcode    string
+	Since    int64
+	Until    int64
+}
+
+// Match returns true if e is selected by f.
+func (f Filter) Match(e *Entry) bool {
+	if f.Records != nil && !f.Records[e.RecordID] {
+		return false
+	}
+	if f.RecordID != "" && e.RecordID != f.RecordID {
+		return false
+	}
+	if f.Resolver != "" && e.Resolver != f.Resolver {
+		return false
+	}
+	if f.QName != "" {
+		name := strings.ToLower(strings.TrimSuffix(e.QName, "."))
+		qname := strings.ToLower(strings.TrimSuffix(f.QName, "."))
+		if name != qname && !strings.HasSuffix(name, "."+qname) {
+			return false
+		}
+	}
+	if f.QType != "" && !strings.EqualFold(e.QType, f.QType) {
+		return false
+	}
+	if f.Rcode != "" && !strings.EqualFold(e.Rcode, f.Rcode) {
+		return false
+	}
+	if f.Since != 0 && e.Time < f.Since {
+		return false
+	}
+	if f.Until != 0 && e.Time > f.Until {
+		return false
+	}
+	return true
+}
+
+// Aggregate summarizes the entries of a single record or resolver. QueriesPerMinute is the
+// average rate between the first and last query, over at least one minute.
+type Aggregate struct {
+	Key              string         `json:"key"`
+	RecordFQDN       string         `json:"record_fqdn,omitempty"`
+	Queries          int            `json:"queries"`
+	QueriesPerMinute float64        `json:"queries_per_minute"`
+	UniqueResolvers  int            `json:"unique_resolvers"`
+	UniqueRecords    int            `json:"unique_records"`
+	Resolvers        map[string]int `json:"resolvers,omitempty"`
+	Rcodes           map[string]int `json:"rcodes"`
+	FirstSeen        int64          `json:"first_seen"`
+	LastSeen         int64          `json:"last_seen"`
+}
+
+// fileQueue is the number of entries that can wait to be written to the file of a Log.
+const fileQueue = 4096
+
+// ErrBehind is returned by Add if an entry is not written to the file because the queue of
+// entries waiting to be written is full.
+var ErrBehind = errors.New("query log file is behind, entries are not being written")
+
+// Log keeps the most recent DNS queries in memory and optionally writes every query to a file
+// as JSON lines.
+type Log struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+	// file queues entries for the goroutine writing them, so that DNS queries do not wait on
+	// the disk. It is nil if there is no file.
+	file chan Entry
+	// errMu guards err, the reason entries are not being written, and whether it has been
+	// returned by Add.
+	errMu    sync.Mutex
+	err      error
+	reported bool
+}
+
+// New returns a Log that keeps size entries. If w is not nil every entry is also written to it.
+func New(size int, w io.Writer) *Log {
+	if size < 1 {
+		size = 1
+	}
+	l := &Log{entries: make([]Entry, size)}
+	if w != nil {
+		l.file = make(chan Entry, fileQueue)
+		go l.write(w)
+	}
+	return l
+}
+
+// Add appends e to the log, replacing the oldest entry if it is full, and queues it to be
+// written to the file. If entries are not being written to the file the error is returned by
+// the first call to Add, and again only once writing has recovered and failed again.
+func (l *Log) Add(e Entry, at time.Time) error {
+	e.Time = at.Unix()
+	e.timeNano = at.UnixNano()
+	l.mu.Lock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	select {
+	case l.file <- e:
+	default:
+		l.fail(ErrBehind)
+	}
+	return l.failure()
+}
+
+// write writes the entries queued by Add to w, flushing whenever the queue is empty. The
+// entries buffered when a write fails are dropped so that writing can recover.
+func (l *Log) write(w io.Writer) {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	for e := range l.file {
+		if err := enc.Encode(&e); err != nil {
+			l.fail(err)
+			buf.Reset(w)
+			continue
+		}
+		if len(l.file) > 0 {
+			continue
+		}
+		if err := buf.Flush(); err != nil {
+			l.fail(err)
+			buf.Reset(w)
+			continue
+		}
+		l.fail(nil)
+	}
+}
+
+// fail records err as the reason entries are not being written, or that they are written again
+// if err is nil.
+func (l *Log) fail(err error) {
+	l.errMu.Lock()
+	defer l.errMu.Unlock()
+	if err == nil || l.err == nil {
+		l.err = err
+		l.reported = false
+	}
+}
+
+// failure returns the reason entries are not being written if it has not been returned before.
+func (l *Log) failure() error {
+	l.errMu.Lock()
+	defer l.errMu.Unlock()
+	if l.err == nil || l.reported {
+		return nil
+	}
+	l.reported = true
+	return l.err
+}
+
+// Find returns the entries selected by f, oldest first. If limit is not zero only the most
+// recent limit entries are returned.
+func (l *Log) Find(f Filter, limit int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	found := []Entry{}
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	for i := 1; i <= n && (limit == 0 || len(found) < limit); i++ {
+		e := &l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if f.Match(e) {
+			found = append(found, *e)
+		}
+	}
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
+
+// Summarize summarizes entries by record id, or by resolver if byResolver is set. Entries that
+// did not match a record are grouped under an empty key and are not counted as unique records.
+func Summarize(entries []Entry, byResolver bool) []Aggregate {
+	aggregates := []Aggregate{}
+	index := map[string]int{}
+	records := map[string]map[string]bool{}
+	first, last := map[string]int64{}, map[string]int64{}
+	for _, e := range entries {
+		key := e.RecordID
+		if byResolver {
+			key = e.Resolver
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(aggregates)
+			index[key] = i
+			a := Aggregate{Key: key, Resolvers: map[string]int{}, Rcodes: map[string]int{}, FirstSeen: e.Time}
+			if !byResolver {
+				a.RecordFQDN = e.RecordFQDN
+			}
+			aggregates = append(aggregates, a)
+			records[key] = map[string]bool{}
+			first[key] = e.timeNano
+		}
+		a := &aggregates[i]
+		a.Queries++
+		a.Resolvers[e.Resolver]++
+		a.Rcodes[e.Rcode]++
+		a.LastSeen = e.Time
+		if e.RecordID != "" {
+			records[key][e.RecordID] = true
+		}
+		last[key] = e.timeNano
+	}
+	for i := range aggregates {
+		a := &aggregates[i]
+		a.UniqueResolvers = len(a.Resolvers)
+		a.UniqueRecords = len(records[a.Key])
+		minutes := time.Duration(last[a.Key] - first[a.Key]).Minutes()
+		if minutes < 1 {
+			minutes = 1
+		}
+		a.QueriesPerMinute = float64(a.Queries) / minutes
+		if byResolver {
+			a.Resolvers = nil
+		}
+	}
+	return aggregates
+}
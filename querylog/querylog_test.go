@@ -0,0 +1,293 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	e := &Entry{
+		Time:     1000,
+		Resolver: "10.0.0.1",
+		QName:    "A.Sub.Example.com.",
+		QType:    "TXT",
+		RecordID: "r1",
+		Rcode:    "NOERROR",
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"record", Filter{RecordID: "r1"}, true},
+		{"other record", Filter{RecordID: "r2"}, false},
+		{"visible records", Filter{Records: map[string]bool{"r1": true, "r2": true}}, true},
+		{"hidden record", Filter{Records: map[string]bool{"r2": true}}, false},
+		{"resolver", Filter{Resolver: "10.0.0.1"}, true},
+		{"other resolver", Filter{Resolver: "10.0.0.2"}, false},
+		{"qname", Filter{QName: "a.sub.example.com"}, true},
+		{"qname parent", Filter{QName: "example.com."}, true},
+		{"qname label boundary", Filter{QName: "ub.example.com"}, false},
+		{"qname child", Filter{QName: "b.a.sub.example.com"}, false},
+		{"qtype", Filter{QType: "txt"}, true},
+		{"other qtype", Filter{QType: "A"}, false},
+		{"rcode", Filter{Rcode: "noerror"}, true},
+		{"other rcode", Filter{Rcode: "NXDOMAIN"}, false},
+		{"since", Filter{Since: 1000}, true},
+		{"after", Filter{Since: 1001}, false},
+		{"until", Filter{Until: 1000}, true},
+		{"before", Filter{Until: 999}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(e); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	unmatched := &Entry{QName: "unknown.example.org."}
+	if (Filter{Records: map[string]bool{}}).Match(unmatched) {
+		t.Error("Records matched an entry without a record")
+	}
+	if !(Filter{}).Match(unmatched) {
+		t.Error("empty filter did not match an entry without a record")
+	}
+}
+
+// names returns the query names of entries.
+func names(entries []Entry) string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.QName)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestFind(t *testing.T) {
+	l := New(3, nil)
+	at := time.Unix(1000, 0)
+	if got := l.Find(Filter{}, 0); len(got) != 0 {
+		t.Fatalf("Find on an empty log = %v", got)
+	}
+	for i, name := range []string{"a", "b"} {
+		l.Add(Entry{QName: name, RecordID: "r1"}, at.Add(time.Duration(i)*time.Second))
+	}
+	if got := names(l.Find(Filter{}, 0)); got != "a,b" {
+		t.Errorf("Find before wrapping = %s, want a,b", got)
+	}
+	for i, name := range []string{"c", "d", "e"} {
+		recordID := "r1"
+		if name == "d" {
+			recordID = "r2"
+		}
+		l.Add(Entry{QName: name, RecordID: recordID}, at.Add(time.Duration(i+2)*time.Second))
+	}
+	tests := []struct {
+		filter Filter
+		limit  int
+		want   string
+	}{
+		{Filter{}, 0, "c,d,e"},
+		{Filter{}, 2, "d,e"},
+		{Filter{}, 5, "c,d,e"},
+		{Filter{RecordID: "r1"}, 0, "c,e"},
+		{Filter{RecordID: "r1"}, 1, "e"},
+		{Filter{Since: 1003}, 0, "d,e"},
+		{Filter{RecordID: "r3"}, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := names(l.Find(tt.filter, tt.limit)); got != tt.want {
+			t.Errorf("Find(%+v, %d) = %s, want %s", tt.filter, tt.limit, got, tt.want)
+		}
+	}
+	if got := l.Find(Filter{}, 0); got[0].Time != 1002 {
+		t.Errorf("Time = %d, want 1002", got[0].Time)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	at := time.Unix(1000, 0)
+	l := New(10, nil)
+	queries := []struct {
+		resolver, recordID, rcode string
+		after                     time.Duration
+	}{
+		{"10.0.0.1", "r1", "NOERROR", 0},
+		{"10.0.0.2", "r1", "NOERROR", time.Minute},
+		{"10.0.0.1", "r1", "NXDOMAIN", 2 * time.Minute},
+		{"10.0.0.1", "r2", "NOERROR", 10 * time.Second},
+		{"10.0.0.3", "", "REFUSED", 20 * time.Second},
+	}
+	for _, q := range queries {
+		l.Add(Entry{Resolver: q.resolver, RecordID: q.recordID, RecordFQDN: q.recordID + ".example.com", Rcode: q.rcode}, at.Add(q.after))
+	}
+	entries := l.Find(Filter{}, 0)
+
+	byRecord := Summarize(entries, false)
+	if len(byRecord) != 3 {
+		t.Fatalf("%d record aggregates, want 3", len(byRecord))
+	}
+	r1 := byRecord[0]
+	if r1.Key != "r1" || r1.RecordFQDN != "r1.example.com" || r1.Queries != 3 || r1.UniqueResolvers != 2 || r1.UniqueRecords != 1 {
+		t.Errorf("unexpected aggregate %+v", r1)
+	}
+	if r1.Rcodes["NOERROR"] != 2 || r1.Rcodes["NXDOMAIN"] != 1 || r1.Resolvers["10.0.0.1"] != 2 {
+		t.Errorf("unexpected counts %+v", r1)
+	}
+	if r1.FirstSeen != 1000 || r1.LastSeen != 1120 || r1.QueriesPerMinute != 1.5 {
+		t.Errorf("first %d, last %d, rate %v, want 1000, 1120, 1.5", r1.FirstSeen, r1.LastSeen, r1.QueriesPerMinute)
+	}
+	if r2 := byRecord[1]; r2.Key != "r2" || r2.QueriesPerMinute != 1 {
+		t.Errorf("a single query is not counted over one minute: %+v", r2)
+	}
+	if none := byRecord[2]; none.Key != "" || none.UniqueRecords != 0 {
+		t.Errorf("unexpected aggregate for unmatched queries %+v", none)
+	}
+
+	byResolver := Summarize(entries, true)
+	if len(byResolver) != 3 {
+		t.Fatalf("%d resolver aggregates, want 3", len(byResolver))
+	}
+	if a := byResolver[0]; a.Key != "10.0.0.1" || a.Queries != 3 || a.UniqueRecords != 2 || a.Resolvers != nil || a.RecordFQDN != "" {
+		t.Errorf("unexpected aggregate %+v", a)
+	}
+	if a := byResolver[2]; a.Key != "10.0.0.3" || a.UniqueRecords != 0 {
+		t.Errorf("unexpected aggregate %+v", a)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be read while the log writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFileWrite(t *testing.T) {
+	w := &syncBuffer{}
+	l := New(1, w)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := l.Add(Entry{QName: name}, time.Unix(1000, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lines []string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if lines = strings.Split(strings.TrimSpace(w.String()), "\n"); len(lines) == 3 {
+			break
+		}
+	}
+	if len(lines) != 3 {
+		t.Fatalf("%d lines written, want 3", len(lines))
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		var e Entry
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.QName != name || e.Time != 1000 {
+			t.Errorf("line %d = %+v", i, e)
+		}
+	}
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestFileErrorReportedOnce(t *testing.T) {
+	l := New(1, failWriter{})
+	var err error
+	for deadline := time.Now().Add(time.Second); err == nil && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		err = l.Add(Entry{}, time.Now())
+	}
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("Add = %v, want disk full", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := l.Add(Entry{}, time.Now()); err != nil {
+			t.Fatalf("Add reported %v again", err)
+		}
+	}
+}
+
+// flakyWriter fails every write until it is fixed.
+type flakyWriter struct {
+	syncBuffer
+	fixed bool
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.fixed {
+		return 0, errors.New("disk full")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *flakyWriter) fix() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fixed = true
+}
+
+func TestFileRecovers(t *testing.T) {
+	w := &flakyWriter{}
+	l := New(1, w)
+	var err error
+	for deadline := time.Now().Add(time.Second); err == nil && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		err = l.Add(Entry{QName: "lost"}, time.Now())
+	}
+	if err == nil {
+		t.Fatal("Add did not report the failed write")
+	}
+	w.fix()
+	for deadline := time.Now().Add(time.Second); !strings.Contains(w.String(), `"recovered"`); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("entries were not written after the writer recovered")
+		}
+		if err := l.Add(Entry{QName: "recovered"}, time.Now()); err != nil {
+			t.Fatalf("Add reported %v again", err)
+		}
+	}
+}
+
+func TestFailureAfterRecovering(t *testing.T) {
+	l := New(1, nil)
+	l.fail(ErrBehind)
+	l.fail(errors.New("disk full"))
+	if err := l.failure(); err != ErrBehind {
+		t.Errorf("failure = %v, want the first error %v", err, ErrBehind)
+	}
+	if err := l.failure(); err != nil {
+		t.Errorf("failure reported %v twice", err)
+	}
+	l.fail(nil)
+	if err := l.failure(); err != nil {
+		t.Errorf("failure after recovering = %v, want nil", err)
+	}
+	l.fail(ErrBehind)
+	if err := l.failure(); err != ErrBehind {
+		t.Errorf("failure after failing again = %v, want %v", err, ErrBehind)
+	}
+}
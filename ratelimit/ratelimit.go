@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket rate limiter keyed by string. Each key may make rate requests per
+// second on average with bursts of up to burst requests. Keys are forgotten once they have
+// been idle for the forget duration.
+type Limiter struct {
+	forget time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+// New returns a Limiter that forgets keys after they have been idle for forget.
+func New(forget time.Duration) *Limiter {
+	return &Limiter{
+		forget:  forget,
+		buckets: map[string]*bucket{},
+		pruned:  time.Now(),
+	}
+}
+
+// Allow takes a token for key and returns false if there were none left. A rate of zero or less
+// allows every request. A burst less than one is treated as one.
+func (l *Limiter) Allow(key string, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.pruned) > l.forget {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > l.forget {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Counter tracks the number of concurrent connections per key.
+type Counter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewCounter returns an empty Counter.
+func NewCounter() *Counter {
+	return &Counter{counts: map[string]int{}}
+}
+
+// Acquire adds a connection for key and returns true, unless key already has max connections.
+// A max of zero or less is unlimited. Every successful Acquire must be followed by a Release.
+func (c *Counter) Acquire(key string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.counts[key] >= max {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+// Release removes a connection for key.
+func (c *Counter) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[key]--
+	if c.counts[key] <= 0 {
+		delete(c.counts, key)
+	}
+}
+
+// Count returns the number of connections key currently has.
+func (c *Counter) Count(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[key]
+}
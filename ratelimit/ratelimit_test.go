@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowBurst(t *testing.T) {
+	l := New(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a", 0.001, 3) {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	if l.Allow("a", 0.001, 3) {
+		t.Error("request past the burst was allowed")
+	}
+	if !l.Allow("b", 0.001, 3) {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	l := New(time.Hour)
+	if !l.Allow("a", 50, 1) {
+		t.Fatal("first request was refused")
+	}
+	if l.Allow("a", 50, 1) {
+		t.Fatal("second request was allowed without a refill")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !l.Allow("a", 50, 1) {
+		t.Error("request after a refill was refused")
+	}
+}
+
+func TestAllowLimits(t *testing.T) {
+	tests := []struct {
+		rate    float64
+		burst   int
+		allowed int
+	}{
+		{0, 0, 10},
+		{-1, 5, 10},
+		{0.001, 0, 1},
+		{0.001, -3, 1},
+		{0.001, 4, 4},
+	}
+	for _, tt := range tests {
+		l := New(time.Hour)
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if l.Allow("a", tt.rate, tt.burst) {
+				allowed++
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("rate %v burst %d allowed %d of 10 requests, want %d", tt.rate, tt.burst, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestAllowForgets(t *testing.T) {
+	l := New(10 * time.Millisecond)
+	l.Allow("a", 1, 1)
+	time.Sleep(20 * time.Millisecond)
+	l.Allow("b", 1, 1)
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle key was not forgotten")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("active key was forgotten")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter()
+	for i := 0; i < 2; i++ {
+		if !c.Acquire("a", 2) {
+			t.Fatalf("connection %d under the cap was refused", i)
+		}
+	}
+	if c.Acquire("a", 2) {
+		t.Error("connection over the cap was allowed")
+	}
+	if !c.Acquire("b", 2) {
+		t.Error("another key shares the count")
+	}
+	if n := c.Count("a"); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+	c.Release("a")
+	if !c.Acquire("a", 2) {
+		t.Error("connection after a release was refused")
+	}
+	c.Release("a")
+	c.Release("a")
+	if _, ok := c.counts["a"]; ok {
+		t.Error("released key was kept")
+	}
+	for i := 0; i < 5; i++ {
+		if !c.Acquire("c", 0) {
+			t.Fatal("connection without a cap was refused")
+		}
+	}
+}
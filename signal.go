@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tomsteele/shellsquid/app"
+	"github.com/tomsteele/shellsquid/handlers"
+)
+
+// handleSignals engages the kill switch on SIGUSR1 and releases it on SIGUSR2.
+func handleSignals(server *app.App) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				handlers.HaltSignal(server, true, "SIGUSR1")
+			} else {
+				handlers.HaltSignal(server, false, "SIGUSR2")
+			}
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/tomsteele/shellsquid/app"
+
+// handleSignals does nothing on Windows, which does not have SIGUSR1 or SIGUSR2. Use the API
+// to engage the kill switch instead.
+func handleSignals(server *app.App) {}
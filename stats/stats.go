@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// Record holds the traffic counters of a single record since the server started.
+type Record struct {
+	Requests          int64 `json:"requests"`
+	RateLimited       int64 `json:"rate_limited"`
+	ConnectionLimited int64 `json:"connection_limited"`
+	ActiveConnections int   `json:"active_connections"`
+	LastRequest       int64 `json:"last_request"`
+}
+
+// Store keeps counters per record id in memory.
+type Store struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+// New returns an empty Store.
+func New() *Store {
+	return &Store{records: map[string]*Record{}}
+}
+
+// update calls fn with the counters for id while holding the lock.
+func (s *Store) update(id string, fn func(r *Record)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		r = &Record{}
+		s.records[id] = r
+	}
+	fn(r)
+}
+
+// Request counts a request routed to id.
+func (s *Store) Request(id string) {
+	s.update(id, func(r *Record) {
+		r.Requests++
+		r.LastRequest = time.Now().Unix()
+	})
+}
+
+// RateLimited counts a request to id refused by a rate limit.
+func (s *Store) RateLimited(id string) {
+	s.update(id, func(r *Record) {
+		r.RateLimited++
+	})
+}
+
+// ConnectionLimited counts a request to id refused by a connection cap.
+func (s *Store) ConnectionLimited(id string) {
+	s.update(id, func(r *Record) {
+		r.ConnectionLimited++
+	})
+}
+
+// Get returns a copy of the counters for id.
+func (s *Store) Get(id string) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[id]; ok {
+		return *r
+	}
+	return Record{}
+}
+
+// Remove forgets the counters for id.
+func (s *Store) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+}
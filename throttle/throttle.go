@@ -0,0 +1,138 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures int
+	last     time.Time
+	next     time.Time
+}
+
+// Limiter tracks failures per key and enforces an exponentially increasing delay between
+// attempts. Keys are forgotten once they have not failed for the forget duration.
+type Limiter struct {
+	base   time.Duration
+	max    time.Duration
+	forget time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New returns a Limiter whose delay starts at base and doubles with each failure up to max.
+func New(base, max, forget time.Duration) *Limiter {
+	return &Limiter{
+		base:    base,
+		max:     max,
+		forget:  forget,
+		entries: map[string]*entry{},
+	}
+}
+
+// Delay returns the exponential delay after the given number of consecutive failures,
+// starting at base and capped at max.
+func Delay(failures int, base, max time.Duration) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// Wait returns how long the caller must wait before key may make another attempt.
+func (l *Limiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	if now.Sub(e.last) > l.forget {
+		delete(l.entries, key)
+		return 0
+	}
+	if now.Before(e.next) {
+		return e.next.Sub(now)
+	}
+	return 0
+}
+
+// Failure records a failed attempt by key.
+func (l *Limiter) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for k, e := range l.entries {
+		if now.Sub(e.last) > l.forget {
+			delete(l.entries, k)
+		}
+	}
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	e.next = now.Add(Delay(e.failures, l.base, l.max))
+}
+
+// Reset forgets all failures for key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// Account is the failed login state of an account.
+type Account struct {
+	Failures    int
+	LastFailure int64
+	LockedUntil int64
+}
+
+// Accounts keeps the failed login state of accounts that are not stored anywhere else, such
+// as logins to unknown accounts. Accounts are forgotten once they have not failed for the
+// forget duration and are no longer locked.
+type Accounts struct {
+	forget time.Duration
+
+	mu      sync.Mutex
+	entries map[string]Account
+}
+
+// NewAccounts returns an empty Accounts.
+func NewAccounts(forget time.Duration) *Accounts {
+	return &Accounts{forget: forget, entries: map[string]Account{}}
+}
+
+// Get returns the state of the account key.
+func (a *Accounts) Get(key string) Account {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.entries[key]
+}
+
+// Set replaces the state of the account key.
+func (a *Accounts) Set(key string, account Account) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().Unix()
+	forget := int64(a.forget / time.Second)
+	for k, e := range a.entries {
+		if now-e.LastFailure > forget && now >= e.LockedUntil {
+			delete(a.entries, k)
+		}
+	}
+	a.entries[key] = account
+}
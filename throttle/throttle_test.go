@@ -0,0 +1,91 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{1000, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := Delay(tt.failures, time.Second, time.Minute); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := New(time.Hour, 4*time.Hour, 24*time.Hour)
+	if wait := l.Wait("a"); wait != 0 {
+		t.Fatalf("Wait before a failure = %v, want 0", wait)
+	}
+	l.Failure("a")
+	if wait := l.Wait("a"); wait <= 59*time.Minute || wait > time.Hour {
+		t.Errorf("Wait after one failure = %v, want about an hour", wait)
+	}
+	l.Failure("a")
+	if wait := l.Wait("a"); wait <= 119*time.Minute || wait > 2*time.Hour {
+		t.Errorf("Wait after two failures = %v, want about two hours", wait)
+	}
+	if wait := l.Wait("b"); wait != 0 {
+		t.Errorf("Wait for another key = %v, want 0", wait)
+	}
+	l.Reset("a")
+	if wait := l.Wait("a"); wait != 0 {
+		t.Errorf("Wait after Reset = %v, want 0", wait)
+	}
+}
+
+func TestLimiterForgets(t *testing.T) {
+	l := New(time.Hour, time.Hour, 10*time.Millisecond)
+	l.Failure("a")
+	l.Failure("b")
+	time.Sleep(20 * time.Millisecond)
+	if wait := l.Wait("a"); wait != 0 {
+		t.Errorf("Wait after forget = %v, want 0", wait)
+	}
+	if _, ok := l.entries["a"]; ok {
+		t.Error("Wait kept a forgotten key")
+	}
+	// Failure prunes every forgotten key, not only its own.
+	l.Failure("c")
+	if _, ok := l.entries["b"]; ok {
+		t.Error("Failure kept a forgotten key")
+	}
+	if len(l.entries) != 1 {
+		t.Errorf("%d keys tracked, want 1", len(l.entries))
+	}
+}
+
+func TestAccountsSetPrunes(t *testing.T) {
+	a := NewAccounts(time.Hour)
+	now := time.Now().Unix()
+	a.Set("stale", Account{Failures: 3, LastFailure: now - 7200})
+	a.Set("locked", Account{Failures: 5, LastFailure: now - 7200, LockedUntil: now + 600})
+	a.Set("recent", Account{Failures: 1, LastFailure: now - 60})
+	a.Set("new", Account{Failures: 1, LastFailure: now})
+
+	if got := a.Get("stale"); got != (Account{}) {
+		t.Errorf("stale account kept as %+v", got)
+	}
+	for _, key := range []string{"locked", "recent", "new"} {
+		if got := a.Get(key); got.Failures == 0 {
+			t.Errorf("account %s was pruned", key)
+		}
+	}
+	if got := a.Get("unknown"); got != (Account{}) {
+		t.Errorf("Get of an unknown account = %+v, want the zero value", got)
+	}
+}
@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the number of seconds each code is valid for.
+	Period = 30
+	// Digits is the length of each code.
+	Digits = 6
+)
+
+// GenerateSecret returns a new random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
+
+// URI returns an otpauth URI for the secret that can be imported by authenticator applications.
+func URI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", Period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code for the secret at the given counter.
+func Code(secret string, counter int64) (string, error) {
+	key, err := base32.StdEncoding.DecodeString(pad(strings.ToUpper(secret)))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(counter), Digits), nil
+}
+
+// hotp returns the HOTP value (RFC 4226) of key at counter truncated to digits decimal digits.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Validate checks code against the secret at time t, allowing for skew periods of clock drift
+// in either direction. The counter of the matching period is returned so that callers can
+// refuse codes that have already been used.
+func Validate(secret, code string, t time.Time, skew int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	counter := t.Unix() / Period
+	for i := -skew; i <= skew; i++ {
+		expected, err := Code(secret, counter+i)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter + i, true
+		}
+	}
+	return 0, false
+}
+
+func pad(s string) string {
+	if l := len(s) % 8; l > 0 {
+		s += strings.Repeat("=", 8-l)
+	}
+	return s
+}
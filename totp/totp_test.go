@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA1 seed used by the test vectors in RFC 6238 Appendix B.
+const rfc6238Key = "12345678901234567890"
+
+var rfc6238Vectors = []struct {
+	time int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestHOTPRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		if code := hotp([]byte(rfc6238Key), uint64(v.time/Period), 8); code != v.code {
+			t.Errorf("hotp at %d = %s, want %s", v.time, code, v.code)
+		}
+	}
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte(rfc6238Key))
+	for _, v := range rfc6238Vectors {
+		want := v.code[len(v.code)-Digits:]
+		code, err := Code(secret, v.time/Period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("Code at %d = %s, want %s", v.time, code, want)
+		}
+		if _, ok := Validate(secret, want, time.Unix(v.time, 0), 0); !ok {
+			t.Errorf("Validate at %d rejected %s", v.time, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte(rfc6238Key))
+	// 287082 is the code for the period starting at 30.
+	if _, ok := Validate(secret, "287082", time.Unix(89, 0), 0); ok {
+		t.Error("Validate accepted a code from the previous period without skew")
+	}
+	counter, ok := Validate(secret, "287082", time.Unix(89, 0), 1)
+	if !ok || counter != 1 {
+		t.Errorf("Validate with skew = %d, %v, want 1, true", counter, ok)
+	}
+}